@@ -0,0 +1,55 @@
+// rekeyshards re-encrypts every shard DB of the configured routes, e.g. after
+// rotating the encryption key or turning encryption on for existing data.
+// The server must be stopped.
+//
+//	rekeyshards --config cfg.yaml --old-key file:/run/old.key
+//
+// moves the shards from the old key to encryption.key of the config; an
+// empty --old-key means they are plaintext now, --new-key "" decrypts them.
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/internal/server"
+	"webhook-engine/internal/zoomapp"
+)
+
+func die(err error) { if err != nil { log.Fatal(err) } }
+
+func main() {
+	var cfgPath, oldRef, newRef string
+	pflag.StringVar(&cfgPath, "config", "docker/configs/zoomapp.yaml", "config path")
+	pflag.StringVar(&oldRef, "old-key", "", `current key or "env:"/"file:" reference; empty if plaintext`)
+	pflag.StringVar(&newRef, "new-key", "", "new key or reference (default: encryption.key of the config)")
+	pflag.Parse()
+
+	rootCfg, err := server.LoadRootConfig(cfgPath)
+	die(err)
+	zcfg, err := zoomapp.Load(cfgPath)
+	die(err)
+	if !pflag.CommandLine.Changed("new-key") { newRef = rootCfg.Encryption.Key }
+	oldKey, newKey := key(oldRef), key(newRef)
+
+	for _, rc := range rootCfg.Routes {
+		dir := server.RouteDir(rc, zcfg.Fastpath.BaseDir, len(rootCfg.Routes))
+		shards, err := filepath.Glob(filepath.Join(dir, "shard-[0-9][0-9]"))
+		die(err)
+		for _, shard := range shards {
+			if err := fastpath.Rekey(shard, oldKey, newKey); err != nil { log.Fatalf("%s: %v", shard, err) }
+			log.Printf("rekeyed %s", shard)
+		}
+	}
+}
+
+func key(ref string) []byte {
+	s, err := server.ResolveSecret(ref)
+	die(err)
+	k, err := fastpath.ParseEncryptionKey(s)
+	die(err)
+	return k
+}
@@ -33,7 +33,9 @@ func main() {
 	flag.Parse()
 	if token == "" { log.Fatal("set ZOOM_WEBHOOK_SECRET_TOKEN or -token") }
 
-	body := bytes.Repeat([]byte("a"), bodySz)
+	// every body is unique so the server's replay cache doesn't reject repeats
+	pad := string(bytes.Repeat([]byte("a"), bodySz))
+	var seq uint64
 	tr := &http.Transport{
 		MaxIdleConns: conns,
 		MaxConnsPerHost: conns,
@@ -51,7 +53,11 @@ func main() {
 	for i:=0;i<workers;i++ {
 		go func(){
 			for range work {
-				ts := strconv.FormatInt(time.Now().Unix(), 10)
+				now := time.Now()
+				ts := strconv.FormatInt(now.Unix(), 10)
+				n := atomic.AddUint64(&seq, 1)
+				body := []byte(`{"event":"stress.test","event_ts":` + strconv.FormatInt(now.UnixMilli(), 10) +
+					`,"payload":{"seq":` + strconv.FormatUint(n, 10) + `,"pad":"` + pad + `"}}`)
 				msg := []byte("v0:"+ts+":"+string(body))
 				h := hmac.New(sha256.New, []byte(token))
 				h.Write(msg)
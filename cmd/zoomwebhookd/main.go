@@ -17,7 +17,6 @@ import (
 	"webhook-engine/internal/zoomapp"
 	"webhook-engine/internal/fastpath"
 	"webhook-engine/pkg/tracing"
-	"webhook-engine/pkg/validators/zoom"
 )
 
 func die(err error) { if err != nil { log.Fatal(err) } }
@@ -51,45 +50,29 @@ func main() {
 	defer shutdownTracing(context.Background())
 
 	app := server.NewApp(rootCfg, logr)
+	if rootCfg.Admin.Token == "" { logr.Warn("admin.token not set; /admin/ endpoints are disabled") }
 
-	// fastpath build
-	var stopFast func() error
+	// fastpath build: one shard set per configured route
+	var stopFast func(context.Context) error
 	if fast || zcfg.Fastpath.Enabled {
-		secret, err := zoom.LoadSecretForCRC(rootCfg.Validators.Zoom.Secret)
-		die(err)
-		token := []byte(secret)
-		shards, stop, err := fastpath.BuildShards(
-			zcfg.Fastpath.Shards,
-			zcfg.Fastpath.BaseDir,
-			zcfg.Fastpath.RingSize,
-			zcfg.Fastpath.ValidatorsPer,
-			zcfg.Fastpath.BatchSize,
-			time.Duration(zcfg.Fastpath.BatchLingerMS)*time.Millisecond,
-			token,
-		)
+		stop, err := app.BuildRoutes(zcfg)
 		die(err)
 		stopFast = stop
-		app.AttachFastRings(shards)
 
 		// metrics ticker
 		go func() {
 			t := time.NewTicker(time.Duration(metricsTick)*time.Millisecond)
 			defer t.Stop()
 			for range t.C {
-				fastpath.ReportShardMetrics(shards)
+				for _, rt := range app.Routes {
+					fastpath.ReportShardMetrics(rt.Name, rt.Shards)
+				}
 			}
 		}()
 	}
 
 	handler := app.FastHandler(zcfg) // includes CRC pre-handler
 
-	// graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() { <-sigs; cancel(); if stopFast!=nil { _=stopFast() } }()
-
 	// Serve: in Docker we use PORT=8080 (HTTP). TLS for bare metal not included in this sample.
 	port := os.Getenv("PORT")
 	if port == "" { port = "8080" }
@@ -99,9 +82,28 @@ func main() {
 		WriteTimeout: 5*time.Second,
 		Name: "zoomwebhookd",
 	}
+
+	// graceful shutdown: stop accepting and finish in-flight requests, then
+	// drain the pipeline, all within server.shutdown_timeout_s
+	drained := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(drained)
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(rootCfg.Server.ShutdownTimeoutS)*time.Second)
+		defer cancel()
+		if err := srv.ShutdownWithContext(ctx); err != nil { logr.WithError(err).Error("server shutdown") }
+		if stopFast != nil {
+			if err := stopFast(ctx); err != nil { logr.WithError(err).Error("fastpath shutdown") }
+		}
+	}()
+
 	logr.WithField("port", port).Warn("serving HTTP")
 	if err := srv.ListenAndServe(":" + port); err != nil {
 		logr.WithError(err).Error("server stopped")
+		return
 	}
+	<-drained
 	_ = strconv.ErrRange // silence unused import on some toolchains
 }
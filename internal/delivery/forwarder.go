@@ -0,0 +1,310 @@
+// Package delivery forwards persisted events to downstream HTTP endpoints.
+//
+// Each destination reads every shard of its route through a consumer group
+// ("delivery:<name>"), so its progress is the group's committed offset in the
+// shard's Badger DB. Attempt counters are stored next to it. Events waiting
+// for a retry move to the redelivery keyspace, out of the way of the ones
+// behind them; events that exhaust their attempts or are rejected
+// permanently go to the shard's dead-letter keyspace.
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/pkg/events"
+	"webhook-engine/pkg/metrics"
+)
+
+const pollInterval = 200 * time.Millisecond
+
+type Destination struct {
+	Name        string
+	URL         string
+	Concurrency int
+	MaxAttempts int
+	Backoff     time.Duration // first retry delay
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+	Format      string // FormatRaw (default), FormatCloudEvents or FormatCloudEventsBinary
+}
+
+// Forwarding formats.
+const (
+	FormatRaw               = "raw"                // the original body
+	FormatCloudEvents       = "cloudevents"        // structured mode
+	FormatCloudEventsBinary = "cloudevents-binary" // binary mode: data as body, attributes as ce-* headers
+)
+
+func (d *Destination) defaults() {
+	if d.Concurrency <= 0 { d.Concurrency = 4 }
+	if d.MaxAttempts <= 0 { d.MaxAttempts = 10 }
+	if d.Backoff <= 0 { d.Backoff = 200 * time.Millisecond }
+	if d.MaxBackoff <= 0 { d.MaxBackoff = time.Minute }
+	if d.Timeout <= 0 { d.Timeout = 5 * time.Second }
+}
+
+// Forwarder delivers one route's events to one destination. Concurrency is
+// shared by all shards of the route.
+type Forwarder struct {
+	Route  string
+	Dest   Destination
+	Log    *logrus.Logger
+	group  string
+	client *http.Client
+	sem    chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Start launches one reader per shard.
+func Start(route string, d Destination, shards []*fastpath.Shard, log *logrus.Logger) *Forwarder {
+	d.defaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		Route:  route,
+		Dest:   d,
+		Log:    log,
+		group:  Group(d.Name),
+		client: &http.Client{Timeout: d.Timeout},
+		sem:    make(chan struct{}, d.Concurrency),
+		cancel: cancel,
+	}
+	for _, s := range shards {
+		f.wg.Add(1)
+		go func(s *fastpath.Shard) { defer f.wg.Done(); f.run(ctx, s) }(s)
+	}
+	return f
+}
+
+// Stop abandons in-flight retries and waits for the readers. Events not yet
+// acknowledged are delivered again after the next start.
+func (f *Forwarder) Stop() {
+	f.cancel()
+	f.wg.Wait()
+}
+
+// Group is the consumer group a destination reads its route's shards with.
+func Group(dest string) string { return "delivery:" + dest }
+
+// shardRun is the delivery state of one shard. An event taken from the
+// consumer group or the redelivery keyspace holds a window slot until its
+// first attempt is done, and the reader takes more whenever slots are free.
+// An event that needs a retry moves to the redelivery keyspace, so the
+// group's offset can pass it, and retries in its own goroutine. At most
+// retryCap events retry at once; past that the reader waits, so a
+// destination that is down does not pull the whole backlog into memory.
+type shardRun struct {
+	s        *fastpath.Shard
+	window   chan struct{}
+	retryCap int
+	freed    chan struct{}
+	mu       sync.Mutex
+	owned    map[string]bool // redelivery keys a goroutine is working on
+	wg       sync.WaitGroup
+}
+
+// room is how many events the reader may take now.
+func (r *shardRun) room() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return min(cap(r.window)-len(r.window), r.retryCap-len(r.owned))
+}
+
+func (r *shardRun) release() {
+	<-r.window
+	select { case r.freed <- struct{}{}: default: }
+}
+
+// claim returns up to n of msgs that no goroutine owns yet, owning them.
+func (r *shardRun) claim(msgs []fastpath.Message, n int) []fastpath.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []fastpath.Message
+	for _, m := range msgs {
+		if len(out) == n { break }
+		if r.owned[string(m.Key)] { continue }
+		r.owned[string(m.Key)] = true
+		out = append(out, m)
+	}
+	return out
+}
+
+func (r *shardRun) own(key []byte) { r.mu.Lock(); r.owned[string(key)] = true; r.mu.Unlock() }
+
+func (r *shardRun) disown(key []byte) {
+	r.mu.Lock()
+	delete(r.owned, string(key))
+	r.mu.Unlock()
+	select { case r.freed <- struct{}{}: default: }
+}
+
+func (f *Forwarder) run(ctx context.Context, s *fastpath.Shard) {
+	batch := 4*f.Dest.Concurrency
+	r := &shardRun{s: s, window: make(chan struct{}, batch), retryCap: 4*batch, freed: make(chan struct{}, 1), owned: map[string]bool{}}
+	defer r.wg.Wait()
+	for ctx.Err() == nil {
+		n := r.room()
+		if n <= 0 {
+			select {
+			case <-ctx.Done():
+			case <-r.freed:
+			}
+			continue
+		}
+		// events requeued from the dead-letter keyspace or waiting for a
+		// retry go first
+		r.mu.Lock()
+		owned := len(r.owned)
+		r.mu.Unlock()
+		re, err := s.Redeliveries(f.group, n+owned)
+		if err != nil { f.Log.WithError(err).WithField("dest", f.Dest.Name).Error("delivery requeue read failed") }
+		if re = r.claim(re, n); len(re) > 0 {
+			for _, m := range re {
+				f.start(ctx, r, m, true)
+			}
+			continue
+		}
+		msgs, err := s.Consumers.Fetch(f.group, n)
+		if err != nil { f.Log.WithError(err).WithField("dest", f.Dest.Name).Error("delivery fetch failed") }
+		if len(msgs) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		for _, m := range msgs {
+			f.start(ctx, r, m, false)
+		}
+	}
+}
+
+func (f *Forwarder) start(ctx context.Context, r *shardRun, m fastpath.Message, redelivery bool) {
+	r.window <- struct{}{}
+	r.wg.Add(1)
+	go func() { defer r.wg.Done(); f.deliver(ctx, r, m, redelivery) }()
+}
+
+// deliver posts m until it succeeds, fails permanently or runs out of
+// attempts, then settles it: acknowledged in the consumer group, or dropped
+// from the redelivery keyspace. Before its first backoff an event from the
+// group is moved to the redelivery keyspace and acknowledged, and its window
+// slot is freed. Shutdown leaves m unsettled, to be delivered after the next
+// start.
+func (f *Forwarder) deliver(ctx context.Context, r *shardRun, m fastpath.Message, redelivery bool) {
+	s := r.s
+	release := sync.OnceFunc(r.release)
+	defer release()
+	if redelivery { defer r.disown(m.Key) }
+	attempts, err := s.DeliveryAttempts(f.group, m.Key)
+	if err != nil { return }
+	v, cause := events.UnmarshalValid(m.Value)
+	for cause == nil {
+		select {
+		case f.sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		retry, err := f.post(ctx, v, m.Key)
+		<-f.sem
+		if ctx.Err() != nil { return }
+		attempts++
+		if err == nil {
+			metrics.DeliveryTotal.WithLabelValues(f.Route, f.Dest.Name, "ok").Inc()
+			if attempts > 1 { _ = s.SetDeliveryAttempts(f.group, m.Key, 0) }
+			break
+		}
+		if !retry || attempts >= f.Dest.MaxAttempts { cause = err; break }
+		metrics.DeliveryTotal.WithLabelValues(f.Route, f.Dest.Name, "retry").Inc()
+		if err := s.SetDeliveryAttempts(f.group, m.Key, attempts); err != nil { return }
+		if !redelivery {
+			// owned first, so the reader does not take it up again
+			r.own(m.Key)
+			defer r.disown(m.Key)
+			if err := s.Redeliver(f.group, m.Key, m.Value); err != nil { return }
+			f.settle(s, m, false)
+			redelivery = true
+		}
+		release()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.backoff(attempts)):
+		}
+	}
+	if cause != nil && !f.dead(s, m, attempts, cause) { return }
+	f.settle(s, m, redelivery)
+}
+
+// settle acknowledges m in the consumer group, or drops it from the
+// redelivery keyspace.
+func (f *Forwarder) settle(s *fastpath.Shard, m fastpath.Message, redelivery bool) {
+	var err error
+	if redelivery {
+		err = s.SettleRedelivery(f.group, m.Key)
+	} else {
+		_, err = s.Consumers.Ack(f.group, [][]byte{m.Key})
+	}
+	if err != nil { f.Log.WithError(err).WithField("dest", f.Dest.Name).Error("delivery settle failed") }
+}
+
+func (f *Forwarder) dead(s *fastpath.Shard, m fastpath.Message, attempts int, cause error) bool {
+	metrics.DeliveryTotal.WithLabelValues(f.Route, f.Dest.Name, "dead").Inc()
+	err := s.DeadLetter(fastpath.DeadEntry{Stage: fastpath.StageDeliver, Dest: f.Dest.Name, EventKey: m.Key, Value: m.Value, Err: cause.Error(), Attempts: attempts})
+	if err != nil {
+		f.Log.WithError(err).WithField("dest", f.Dest.Name).Error("dead-letter write failed")
+		return false
+	}
+	_ = s.SetDeliveryAttempts(f.group, m.Key, 0)
+	return true
+}
+
+// backoff is exponential with full jitter: uniform in [0, min(max, base·2^(n-1))).
+func (f *Forwarder) backoff(attempt int) time.Duration {
+	d := f.Dest.Backoff << (attempt - 1)
+	if d <= 0 || d > f.Dest.MaxBackoff { d = f.Dest.MaxBackoff }
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// post sends the event in the destination's format. Network errors, 408,
+// 429 and 5xx are retried; any other non-2xx status is permanent.
+func (f *Forwarder) post(ctx context.Context, v events.Valid, key []byte) (retry bool, err error) {
+	body, ctype := v.Raw.Body, "application/json"
+	var hdrs map[string]string
+	switch f.Dest.Format {
+	case FormatCloudEvents:
+		if body, err = events.MarshalCloudEvent(v); err != nil { return false, err }
+		ctype = "application/cloudevents+json"
+	case FormatCloudEventsBinary:
+		ce := events.ToCloudEvent(v)
+		body, hdrs = ce.Body(), ce.HTTPHeaders() // Content-Type included
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.Dest.URL, bytes.NewReader(body))
+	if err != nil { return false, err }
+	req.Header.Set("Content-Type", ctype)
+	for name, val := range hdrs {
+		req.Header.Set(name, val)
+	}
+	req.Header.Set("X-Webhook-Route", f.Route)
+	req.Header.Set("X-Webhook-Source", v.Raw.Source)
+	req.Header.Set("X-Webhook-Key", hex.EncodeToString(key))
+	if v.Raw.EventType != "" { req.Header.Set("X-Webhook-Event-Type", v.Raw.EventType) }
+	if v.Raw.Traceparent != "" { req.Header.Set("Traceparent", v.Raw.Traceparent) }
+	resp, err := f.client.Do(req)
+	if err != nil { return true, err }
+	_, _ = io.Copy(io.Discard, resp.Body); resp.Body.Close()
+	if resp.StatusCode/100 == 2 { return false, nil }
+	err = fmt.Errorf("%s: HTTP %d", f.Dest.URL, resp.StatusCode)
+	return resp.StatusCode == 408 || resp.StatusCode == 429 || resp.StatusCode >= 500, err
+}
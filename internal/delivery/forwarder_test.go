@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/validators"
+)
+
+// An event the destination keeps rejecting must not hold up the others.
+func TestFailingEventDoesNotBlockShard(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got[string(b)]++
+		mu.Unlock()
+		if string(b) == `"poison"` { w.WriteHeader(503); return }
+	}))
+	defer srv.Close()
+
+	shards, stop, err := fastpath.BuildShards(fastpath.Options{Route: "test", Shards: 1, BaseDir: t.TempDir(), RingSize: 64,
+		ValidatorsPer: 1, BatchSize: 8, Linger: time.Millisecond})
+	if err != nil { t.Fatal(err) }
+	defer stop(context.Background())
+	s := shards[0]
+	push := func(body string) {
+		ev := fastqueue.Event{Body: []byte(body), Recv: time.Now().UnixNano(), Verified: true, Meta: validators.Meta{Source: "test"}}
+		if ok, err := s.Enqueue(ev, false); !ok || err != nil { t.Fatalf("enqueue %s: %v %v", body, ok, err) }
+	}
+	push(`"poison"`)
+	for i := 0; i < 20; i++ {
+		push(fmt.Sprintf(`"ok-%d"`, i))
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	f := Start("test", Destination{Name: "d", URL: srv.URL, Concurrency: 1, MaxAttempts: 100, Backoff: time.Minute, MaxBackoff: time.Minute}, shards, log)
+	defer f.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := 0
+		for i := 0; i < 20; i++ {
+			n += min(got[fmt.Sprintf(`"ok-%d"`, i)], 1)
+		}
+		mu.Unlock()
+		if n == 20 { break }
+		if time.Now().After(deadline) { t.Fatalf("only %d of 20 events delivered behind a failing one", n) }
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the failing event waits for its retry outside the group's offset
+	last, _ := s.Store.Last()
+	for {
+		re, err := s.Redeliveries(Group("d"), 10)
+		if err != nil { t.Fatal(err) }
+		groups, err := s.Consumers.Groups()
+		if err != nil { t.Fatal(err) }
+		if len(re) == 1 && string(groups[Group("d")]) == string(last) { break }
+		if time.Now().After(deadline) { t.Fatal("group offset did not pass the failing event") }
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,43 +1,205 @@
 package fastpath
 
 import (
-	"encoding/binary"
-	"math/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
+
+	"webhook-engine/pkg/metrics"
 )
 
+// Record is a validated, encoded event on its way to the batch writer.
+type Record struct {
+	Recv  int64 // receive time, unix ns
+	Value []byte
+	Body  []byte // request body, hashed for dedupe when ID is empty
+	ID    string // provider idempotency key, see validators.Meta
+	Done  chan<- error // see fastqueue.Event
+	WALSeq uint64
+}
+
+// writeAttempts bounds how often a failed batch is rewritten before its
+// records are dead-lettered.
+const writeAttempts = 3
+
 type BatchWriter struct {
-	DB     *badger.DB
-	In     <-chan []byte
+	DB     *badger.DB // metadata: idempotency keys, WAL watermark
+	Store  Store      // events
+	In     <-chan Record
 	MaxN   int
 	Linger time.Duration
+	// Flushed is advanced to the last key of every successful flush; readers
+	// that must not skip events stop there (a WriteBatch may commit its
+	// internal transactions out of order).
+	Flushed *atomic.Pointer[[]byte]
+	// Persisted, when set, counts written records.
+	Persisted *atomic.Int64
+	// DeadLetter receives the records of a batch that could not be written.
+	DeadLetter func(DeadEntry) error
+	// WAL, when set, learns which logged events are settled.
+	WAL *WAL
+	// TTL, when positive, expires written events (retention max age).
+	TTL time.Duration
+	// Dedupe, when positive, drops records whose idempotency key (Record.ID,
+	// or a hash of the body) was stored within that window.
+	Dedupe      time.Duration
+	OnDuplicate func()
+	// OnError is called for every failed write attempt.
+	OnError func(error)
+	health  *health
+	keys    keyGen
+}
+
+type keyed struct {
+	key []byte
+	rec Record
+	idem []byte // PrefixIdem key when deduplicating
 }
 
 func (w *BatchWriter) Run() {
-	wb := w.DB.NewWriteBatch(); defer wb.Cancel()
+	w.keys.prefix = PrefixEvent
+	if w.health == nil { w.health = &health{} }
+	if last, err := w.Store.Last(); err == nil {
+		w.keys.seed(last)
+		w.Flushed.Store(&last)
+	}
 	timer := time.NewTimer(w.Linger); defer timer.Stop()
 
-	n := 0
+	batch := make([]keyed, 0, w.MaxN)
+	flush := func() {
+		w.flush(batch)
+		batch = batch[:0]
+	}
 	for {
 		select {
-		case b, ok := <-w.In:
+		case r, ok := <-w.In:
 			if !ok {
-				if n>0 { _ = wb.Flush() }
+				if len(batch)>0 { flush() }
 				return
 			}
-			k := make([]byte, 8); binary.LittleEndian.PutUint64(k, rand.Uint64())
-			_ = wb.SetEntry(badger.NewEntry(k, b))
-			n++
-			if n >= w.MaxN {
-				_ = wb.Flush(); n = 0
+			kv := keyed{key: w.keys.next(r.Recv), rec: r}
+			if w.Dedupe > 0 { kv.idem = idemKey(r) }
+			batch = append(batch, kv)
+			if len(batch) >= w.MaxN {
+				flush()
 				if !timer.Stop() { select { case <-timer.C: default: } }
 				timer.Reset(w.Linger)
 			}
 		case <-timer.C:
-			if n>0 { _ = wb.Flush(); n = 0 }
+			if len(batch)>0 {
+				flush()
+			} else if w.health.down.Load() {
+				if err := w.health.probe(w.DB); err != nil && w.OnError != nil { w.OnError(err) }
+			} else {
+				// validator rejects settle WAL entries between flushes too
+				_ = w.WAL.Checkpoint(w.DB)
+			}
 			timer.Reset(w.Linger)
 		}
 	}
 }
+
+// flush writes batch, rewriting it up to writeAttempts times. Records of a
+// batch that still fails go to the dead-letter keyspace with the last error.
+// Either way every record's Done channel learns the outcome; only records
+// stored in one of the two are settled in the WAL.
+func (w *BatchWriter) flush(batch []keyed) {
+	if w.Dedupe > 0 {
+		if batch = w.dedupe(batch); len(batch) == 0 { return }
+	}
+	var err error
+	for attempt := 0; attempt < writeAttempts; attempt++ {
+		if err = w.write(batch); err == nil { break }
+		if w.OnError != nil { w.OnError(err) }
+	}
+	w.health.record(err)
+	if err == nil {
+		last := batch[len(batch)-1].key
+		w.Flushed.Store(&last)
+		if w.Persisted != nil { w.Persisted.Add(int64(len(batch))) }
+	}
+	for _, kv := range batch {
+		kept := err == nil
+		if err != nil {
+			de := DeadEntry{Stage: StagePersist, EventKey: kv.key, Value: kv.rec.Value, Err: err.Error(), Attempts: writeAttempts}
+			kept = w.DeadLetter != nil && w.DeadLetter(de) == nil
+			if !kept { metrics.LostTotal.WithLabelValues(StagePersist).Inc() }
+		}
+		if kv.rec.Done != nil { kv.rec.Done <- err }
+		// an event stored nowhere stays in the WAL for the next start
+		if kept { w.WAL.Done(kv.rec.WALSeq) }
+	}
+	_ = w.WAL.Checkpoint(w.DB)
+}
+
+// write appends batch to the store, then records its idempotency keys. Those
+// are best effort: once the events are stored, failing the batch would only
+// write them again.
+func (w *BatchWriter) write(batch []keyed) error {
+	kvs := make([]KV, len(batch))
+	for i, kv := range batch {
+		kvs[i] = KV{Key: kv.key, Value: kv.rec.Value}
+	}
+	if err := w.Store.AppendBatch(kvs, w.TTL); err != nil { return err }
+	if w.Dedupe <= 0 { return nil }
+	wb := w.DB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, kv := range batch {
+		if err := wb.SetEntry(badger.NewEntry(kv.idem, kv.key).WithTTL(w.Dedupe)); err != nil { w.idemErr(err); return nil }
+	}
+	w.idemErr(wb.Flush())
+	return nil
+}
+
+func (w *BatchWriter) idemErr(err error) {
+	if err != nil && w.OnError != nil { w.OnError(err) }
+}
+
+func idemKey(r Record) []byte {
+	if r.ID != "" { return append([]byte{PrefixIdem}, r.ID...) }
+	b := r.Body
+	if b == nil { b = r.Value }
+	sum := sha256.Sum256(b)
+	return append([]byte{PrefixIdem}, "sha256:"+hex.EncodeToString(sum[:16])...)
+}
+
+// dedupe settles and drops the records of batch whose idempotency key is
+// already stored or appears earlier in the batch. A failed lookup keeps the
+// record: a duplicate beats a loss.
+func (w *BatchWriter) dedupe(batch []keyed) []keyed {
+	out := batch[:0]
+	seen := make(map[string]bool, len(batch))
+	err := w.DB.View(func(txn *badger.Txn) error {
+		for _, kv := range batch {
+			dup := seen[string(kv.idem)]
+			if !dup {
+				_, err := txn.Get(kv.idem)
+				dup = err == nil
+			}
+			seen[string(kv.idem)] = true
+			if !dup { out = append(out, kv); continue }
+			if w.OnDuplicate != nil { w.OnDuplicate() }
+			if kv.rec.Done != nil { kv.rec.Done <- nil }
+			w.WAL.Done(kv.rec.WALSeq)
+		}
+		return nil
+	})
+	if err != nil { return batch } // the callback never ran, batch is intact
+	return out
+}
+
+// lastKey returns the greatest key under prefix, or nil when there is none.
+func lastKey(db *badger.DB, prefix byte) ([]byte, error) {
+	var k []byte
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Reverse: true, Prefix: []byte{prefix}})
+		defer it.Close()
+		it.Seek([]byte{prefix + 1})
+		if it.Valid() { k = it.Item().KeyCopy(nil) }
+		return nil
+	})
+	return k, err
+}
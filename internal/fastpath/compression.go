@@ -0,0 +1,214 @@
+package fastpath
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression modes.
+const (
+	CompressNone   = "none"
+	CompressSnappy = "snappy" // blocks only
+	CompressZstd   = "zstd"
+)
+
+// CompressionOptions picks how a shard compresses. Block compresses Badger's
+// table blocks; Value compresses every stored event on its own, which also
+// shrinks the value log and the segments store. Small events compress badly
+// alone, so with Dictionary set the shard trains a zstd dictionary on the
+// events it stores and replaces it every Retrain.
+type CompressionOptions struct {
+	Block       string // CompressNone (default), CompressSnappy or CompressZstd
+	Value       string // CompressNone (default) or CompressZstd
+	Level       int    // zstd level, 1 (fastest, default) to 22
+	Dictionary  bool
+	DictSamples int           // events sampled per training (default 1000)
+	Retrain     time.Duration // default 24h
+}
+
+const (
+	DefaultDictSamples = 1000
+	DefaultRetrain     = 24 * time.Hour
+	maxDictSize        = 64 << 10
+)
+
+func (c CompressionOptions) apply(opts badger.Options) badger.Options {
+	switch c.Block {
+	case CompressSnappy:
+		return opts.WithCompression(options.Snappy)
+	case CompressZstd:
+		if c.Level <= 0 { c.Level = 1 }
+		return opts.WithCompression(options.ZSTD).WithZSTDCompressionLevel(c.Level)
+	}
+	return opts.WithCompression(options.None)
+}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// codecStore compresses the values of the Store it wraps. Compressed values
+// are zstd frames, which carry the ID of their dictionary; anything else is
+// returned as stored, so events written before compression was turned on
+// (or after it was turned off) read fine. Dictionaries are kept under
+// PrefixDict for as long as the shard exists.
+type codecStore struct {
+	Store
+	db *badger.DB
+	o  CompressionOptions
+
+	enc *zstd.Encoder // nil when values are stored as is
+	dec atomic.Pointer[zstd.Decoder]
+
+	raw, stored atomic.Int64 // value bytes appended since open
+
+	mu       sync.Mutex // guards enc and dicts during a swap
+	dicts    [][]byte
+	samples  [][]byte // writer goroutine only
+	training atomic.Bool
+	trained  time.Time
+}
+
+func newCodecStore(st Store, db *badger.DB, o CompressionOptions) (*codecStore, error) {
+	if o.Level <= 0 { o.Level = 1 }
+	if o.DictSamples <= 0 { o.DictSamples = DefaultDictSamples }
+	if o.Retrain <= 0 { o.Retrain = DefaultRetrain }
+	c := &codecStore{Store: st, db: db, o: o}
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixDict}, PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			d, err := it.Item().ValueCopy(nil)
+			if err != nil { return err }
+			c.dicts = append(c.dicts, d)
+		}
+		return nil
+	})
+	if err != nil { return nil, err }
+	if err := c.swap(nil); err != nil { return nil, err }
+	if o.Value == CompressZstd && len(c.dicts) > 0 { c.trained = time.Now() }
+	return c, nil
+}
+
+// swap adds d (if any) to the known dictionaries and rebuilds the decoder,
+// and the encoder around the newest one.
+func (c *codecStore) swap(d []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dicts := c.dicts
+	if d != nil { dicts = append(dicts[:len(dicts):len(dicts)], d) }
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dicts...))
+	if err != nil { return err }
+	if c.o.Value == CompressZstd {
+		eo := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.o.Level))}
+		if c.o.Dictionary && len(dicts) > 0 { eo = append(eo, zstd.WithEncoderDict(dicts[len(dicts)-1])) }
+		enc, err := zstd.NewWriter(nil, eo...)
+		if err != nil { return err }
+		c.enc = enc
+	}
+	c.dicts = dicts
+	// readers may still hold the old decoder; without a reader attached it
+	// owns no goroutines, so it is left to the GC
+	c.dec.Store(dec)
+	return nil
+}
+
+func (c *codecStore) AppendBatch(kvs []KV, ttl time.Duration) error {
+	c.mu.Lock()
+	enc := c.enc
+	c.mu.Unlock()
+	if enc == nil { return c.Store.AppendBatch(kvs, ttl) }
+	out := make([]KV, len(kvs))
+	var raw, stored int64
+	for i, kv := range kvs {
+		v := enc.EncodeAll(kv.Value, make([]byte, 0, len(kv.Value)/2))
+		if len(v) >= len(kv.Value) && !bytes.HasPrefix(kv.Value, zstdMagic) { v = kv.Value } // incompressible
+		out[i] = KV{Key: kv.Key, Value: v}
+		raw += int64(len(kv.Value)); stored += int64(len(v))
+	}
+	if err := c.Store.AppendBatch(out, ttl); err != nil { return err }
+	c.raw.Add(raw); c.stored.Add(stored)
+	c.sample(kvs)
+	return nil
+}
+
+// sample collects training input and starts a training run once enough is
+// there and the current dictionary is due.
+func (c *codecStore) sample(kvs []KV) {
+	if c.o.Value != CompressZstd || !c.o.Dictionary || c.training.Load() || time.Since(c.trained) < c.o.Retrain { return }
+	for _, kv := range kvs {
+		if len(c.samples) == c.o.DictSamples { break }
+		c.samples = append(c.samples, append([]byte(nil), kv.Value...))
+	}
+	if len(c.samples) < c.o.DictSamples { return }
+	samples := c.samples
+	c.samples = nil
+	c.training.Store(true)
+	go func() {
+		defer c.training.Store(false)
+		_ = c.train(samples) // a failed run is retried with the next dictionary
+		c.trained = time.Now()
+	}()
+}
+
+func (c *codecStore) train(samples [][]byte) error {
+	c.mu.Lock()
+	id := uint32(len(c.dicts) + 1)
+	c.mu.Unlock()
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: maxDictSize, HashBytes: 6, ZstdDictID: id,
+		ZstdLevel: zstd.EncoderLevelFromZstd(c.o.Level)})
+	if err != nil { return err }
+	// stored before any value can depend on it
+	key := binary.BigEndian.AppendUint32([]byte{PrefixDict}, id)
+	if err := c.db.Update(func(txn *badger.Txn) error { return txn.Set(key, d) }); err != nil { return err }
+	return c.swap(d)
+}
+
+func (c *codecStore) decode(v []byte, buf []byte) ([]byte, error) {
+	if !bytes.HasPrefix(v, zstdMagic) { return v, nil }
+	return c.dec.Load().DecodeAll(v, buf[:0])
+}
+
+func (c *codecStore) Get(key []byte) ([]byte, error) {
+	v, err := c.Store.Get(key)
+	if err != nil { return nil, err }
+	return c.decode(v, nil)
+}
+
+func (c *codecStore) Scan(from, to []byte, fn func(key, val []byte) bool) error {
+	var buf []byte
+	var derr error
+	err := c.Store.Scan(from, to, func(key, val []byte) bool {
+		v, err := c.decode(val, buf)
+		if err != nil { derr = err; return false }
+		if len(v) > 0 && bytes.HasPrefix(val, zstdMagic) { buf = v }
+		return fn(key, v)
+	})
+	if err != nil { return err }
+	return derr
+}
+
+// ValueRatio is raw over stored value bytes appended since open, or 0 before
+// the first compressed append.
+func (c *codecStore) ValueRatio() float64 {
+	stored := c.stored.Load()
+	if stored == 0 { return 0 }
+	return float64(c.raw.Load()) / float64(stored)
+}
+
+// BlockRatio is the uncompressed over on-disk size of the shard's tables,
+// or 0 while there are none.
+func (s *Shard) BlockRatio() float64 {
+	var raw, disk float64
+	for _, t := range s.DB.Tables() {
+		raw += float64(t.UncompressedSize); disk += float64(t.OnDiskSize)
+	}
+	if disk == 0 { return 0 }
+	return raw / disk
+}
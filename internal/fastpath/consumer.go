@@ -0,0 +1,146 @@
+package fastpath
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+const DefaultVisibility = 30 * time.Second
+
+// Message is one event handed to a consumer group.
+type Message struct {
+	Key   []byte
+	Value []byte
+}
+
+// Consumers tracks the named consumer groups reading one shard with
+// at-least-once semantics. A group's committed offset is the key of the last
+// event acknowledged together with everything before it. It is stored under
+// PrefixCursor, so after a restart the group resumes right behind it and
+// anything fetched but not acknowledged is delivered again.
+type Consumers struct {
+	shard      *Shard
+	visibility time.Duration
+	mu         sync.Mutex
+	groups     map[string]*group
+}
+
+type group struct {
+	committed []byte
+	next      []byte // last key handed out
+	pending   []pending
+}
+
+type pending struct {
+	key     []byte
+	fetched time.Time
+	acked   bool
+}
+
+// ErrGroupName rejects names that would not fit the key layout: group names
+// end at a NUL in the delivery and redelivery keys.
+var ErrGroupName = errors.New("consumer group name must be 1-128 bytes without NUL")
+
+func NewConsumers(s *Shard, visibility time.Duration) *Consumers {
+	if visibility <= 0 { visibility = DefaultVisibility }
+	return &Consumers{shard: s, visibility: visibility, groups: map[string]*group{}}
+}
+
+func cursorKey(name string) []byte { return append([]byte{PrefixCursor}, name...) }
+
+// load returns the in-memory state of name, reading its committed offset on
+// first use. c.mu must be held.
+func (c *Consumers) load(name string) (*group, error) {
+	if len(name) == 0 || len(name) > 128 || strings.IndexByte(name, 0) >= 0 { return nil, ErrGroupName }
+	if g, ok := c.groups[name]; ok { return g, nil }
+	g := &group{}
+	err := c.shard.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(cursorKey(name))
+		if errors.Is(err, badger.ErrKeyNotFound) { return nil }
+		if err != nil { return err }
+		g.committed, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil { return nil, err }
+	g.next = g.committed
+	c.groups[name] = g
+	return g, nil
+}
+
+// Fetch hands out up to max flushed events behind the group's read position. When
+// the oldest unacknowledged event has been out longer than the visibility
+// timeout the group rewinds to its committed offset and redelivers.
+func (c *Consumers) Fetch(name string, max int) ([]Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, err := c.load(name)
+	if err != nil { return nil, err }
+	now := time.Now()
+	for _, p := range g.pending {
+		if p.acked { continue }
+		if now.Sub(p.fetched) > c.visibility { g.next, g.pending = g.committed, nil }
+		break
+	}
+	flushed := c.shard.flushed.Load()
+	if flushed == nil || len(*flushed) == 0 { return nil, nil }
+	var from []byte
+	if len(g.next) > 0 { from = append(append([]byte(nil), g.next...), 0) }
+	var out []Message
+	err = c.shard.Scan(from, append(append([]byte(nil), *flushed...), 0), func(key, val []byte) bool {
+		out = append(out, Message{Key: append([]byte(nil), key...), Value: append([]byte(nil), val...)})
+		return len(out) < max
+	})
+	if err != nil { return nil, err }
+	for _, m := range out {
+		g.pending = append(g.pending, pending{key: m.Key, fetched: now})
+	}
+	if len(out) > 0 { g.next = out[len(out)-1].Key }
+	return out, nil
+}
+
+// Ack marks keys as processed and commits the offset past every leading
+// acknowledged event. Keys the group does not have outstanding are ignored.
+// It returns the committed offset.
+func (c *Consumers) Ack(name string, keys [][]byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, err := c.load(name)
+	if err != nil { return nil, err }
+	acked := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		acked[string(k)] = true
+	}
+	for i := range g.pending {
+		if acked[string(g.pending[i].key)] { g.pending[i].acked = true }
+	}
+	n := 0
+	for n < len(g.pending) && g.pending[n].acked {
+		n++
+	}
+	if n == 0 { return g.committed, nil }
+	committed := g.pending[n-1].key
+	err = c.shard.DB.Update(func(txn *badger.Txn) error { return txn.Set(cursorKey(name), committed) })
+	if err != nil { return g.committed, err }
+	g.committed, g.pending = committed, g.pending[n:]
+	return committed, nil
+}
+
+// Groups returns the committed offset of every group stored on the shard.
+func (c *Consumers) Groups() (map[string][]byte, error) {
+	out := map[string][]byte{}
+	err := c.shard.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixCursor}, PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil { return err }
+			out[string(it.Item().Key()[1:])] = v
+		}
+		return nil
+	})
+	return out, err
+}
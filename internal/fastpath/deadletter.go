@@ -0,0 +1,162 @@
+package fastpath
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"webhook-engine/pkg/events"
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/validators"
+)
+
+// Stages at which an event can end up in the dead-letter keyspace.
+const (
+	StageMarshal = "marshal" // envelope encoding failed; Value is the raw body
+	StagePersist = "persist" // Badger write failed; Value is the envelope
+	StageDeliver = "deliver" // forwarding gave up; Value is the envelope
+)
+
+// DeadEntry is an event that could not be persisted or delivered. It is
+// stored under PrefixDead with a time-ordered key.
+type DeadEntry struct {
+	Key       []byte `json:"-"`
+	Stage     string `json:"stage"`
+	Dest      string `json:"dest,omitempty"`
+	EventKey  []byte `json:"event_key,omitempty"`
+	Source    string `json:"source,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+	Recv      int64  `json:"received_at,omitempty"`
+	Value     []byte `json:"value"`
+	Err       string `json:"error"`
+	Attempts  int    `json:"attempts"`
+	At        int64  `json:"at"`
+}
+
+// DeadFilter selects dead-letter entries; empty fields match everything.
+type DeadFilter struct {
+	Stage string
+	Dest  string
+}
+
+func (f DeadFilter) match(e DeadEntry) bool {
+	return (f.Stage == "" || f.Stage == e.Stage) && (f.Dest == "" || f.Dest == e.Dest)
+}
+
+// openDeadLetters seeds the key generator and depth from the stored entries.
+func (s *Shard) openDeadLetters() error {
+	s.deadKeys.prefix = PrefixDead
+	return s.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixDead}})
+		defer it.Close()
+		var n int64
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+			s.deadKeys.seed(it.Item().Key())
+		}
+		s.deadDepth.Store(n)
+		return nil
+	})
+}
+
+// DeadLetter records e in the shard's dead-letter keyspace.
+func (s *Shard) DeadLetter(e DeadEntry) error {
+	if e.At == 0 { e.At = time.Now().UnixNano() }
+	v, err := json.Marshal(e)
+	if err != nil { return err }
+	s.deadMu.Lock()
+	k := s.deadKeys.next(e.At)
+	s.deadMu.Unlock()
+	if err := s.DB.Update(func(txn *badger.Txn) error { return txn.Set(k, v) }); err != nil { return err }
+	s.deadDepth.Add(1)
+	return nil
+}
+
+// DeadDepth is the number of entries in the dead-letter keyspace.
+func (s *Shard) DeadDepth() int64 { return s.deadDepth.Load() }
+
+// DeadLetters returns up to limit entries matching f, oldest first,
+// starting after key after.
+func (s *Shard) DeadLetters(f DeadFilter, after []byte, limit int) ([]DeadEntry, error) {
+	var out []DeadEntry
+	err := s.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixDead}, PrefetchValues: true})
+		defer it.Close()
+		it.Rewind()
+		if len(after) > 0 {
+			it.Seek(after)
+			if it.Valid() && string(it.Item().Key()) == string(after) { it.Next() }
+		}
+		for ; it.Valid() && len(out) < limit; it.Next() {
+			var e DeadEntry
+			if err := it.Item().Value(func(v []byte) error { return json.Unmarshal(v, &e) }); err != nil { return err }
+			if !f.match(e) { continue }
+			e.Key = it.Item().KeyCopy(nil)
+			out = append(out, e)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// DeleteDead removes dead-letter entries by key.
+func (s *Shard) DeleteDead(keys ...[]byte) error {
+	var n int64
+	err := s.DB.Update(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			if _, err := txn.Get(k); errors.Is(err, badger.ErrKeyNotFound) { continue }
+			if err := txn.Delete(k); err != nil { return err }
+			n++
+		}
+		return nil
+	})
+	if err == nil { s.deadDepth.Add(-n) }
+	return err
+}
+
+// Event rebuilds the verified event behind a StagePersist or StageMarshal
+// entry, to be fed back through its shard.
+func (e DeadEntry) Event() (fastqueue.Event, error) {
+	if e.Stage == StageMarshal {
+		return fastqueue.Event{Body: e.Value, Recv: e.Recv, Verified: true, Meta: validators.Meta{Source: e.Source, EventType: e.EventType}}, nil
+	}
+	v, err := events.UnmarshalValid(e.Value)
+	if err != nil { return fastqueue.Event{}, err }
+	r := v.Raw
+	return fastqueue.Event{Body: r.Body, Recv: r.ReceivedAt, Verified: true, Check: r.Verification, Remote: r.RemoteIP, Trace: r.Traceparent, Kept: r.Headers,
+		Meta: validators.Meta{Source: r.Source, EventType: r.EventType, EventTS: r.EventTS, ID: r.ID}}, nil
+}
+
+// Redeliver requeues a dead-lettered delivery for group. The envelope is kept
+// under PrefixRedeliver, keyed like the attempt counters, until the group's
+// forwarder settles it.
+func (s *Shard) Redeliver(group string, key, value []byte) error {
+	k := append([]byte{PrefixRedeliver}, deliveryKey(group, key)[1:]...)
+	return s.DB.Update(func(txn *badger.Txn) error { return txn.Set(k, value) })
+}
+
+// Redeliveries returns up to max requeued events of group.
+func (s *Shard) Redeliveries(group string, max int) ([]Message, error) {
+	prefix := append([]byte{PrefixRedeliver}, group...)
+	prefix = append(prefix, 0)
+	var out []Message
+	err := s.DB.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid() && len(out) < max; it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil { return err }
+			out = append(out, Message{Key: it.Item().KeyCopy(nil)[len(prefix):], Value: v})
+		}
+		return nil
+	})
+	return out, err
+}
+
+// SettleRedelivery drops a requeued event once its forwarder is done with it.
+func (s *Shard) SettleRedelivery(group string, key []byte) error {
+	k := append([]byte{PrefixRedeliver}, deliveryKey(group, key)[1:]...)
+	return s.DB.Update(func(txn *badger.Txn) error { return txn.Delete(k) })
+}
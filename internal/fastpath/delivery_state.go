@@ -0,0 +1,45 @@
+package fastpath
+
+import (
+	"encoding/binary"
+	"errors"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Delivery attempt counters live under PrefixDelivery, keyed by consumer
+// group and event key, so retries continue from the right attempt after a
+// restart instead of starting over.
+func deliveryKey(group string, key []byte) []byte {
+	k := make([]byte, 0, 2+len(group)+len(key))
+	k = append(k, PrefixDelivery)
+	k = append(k, group...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// DeliveryAttempts returns how often group already tried to deliver key.
+func (s *Shard) DeliveryAttempts(group string, key []byte) (int, error) {
+	n := 0
+	err := s.DB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(deliveryKey(group, key))
+		if errors.Is(err, badger.ErrKeyNotFound) { return nil }
+		if err != nil { return err }
+		return item.Value(func(v []byte) error {
+			if len(v) == 4 { n = int(binary.BigEndian.Uint32(v)) }
+			return nil
+		})
+	})
+	return n, err
+}
+
+// SetDeliveryAttempts stores the attempt count of key; zero clears it.
+func (s *Shard) SetDeliveryAttempts(group string, key []byte, n int) error {
+	k := deliveryKey(group, key)
+	return s.DB.Update(func(txn *badger.Txn) error {
+		if n == 0 { return txn.Delete(k) }
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, uint32(n))
+		return txn.Set(k, v)
+	})
+}
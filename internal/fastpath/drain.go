@@ -0,0 +1,68 @@
+package fastpath
+
+import (
+	"context"
+	"sync"
+)
+
+// DrainStats summarises a shutdown: Drained events were persisted after it
+// began, Lost ones were still queued when the deadline passed. Spilled
+// events stay on disk for the next start and count as neither.
+type DrainStats struct {
+	Drained int64
+	Lost    int64
+}
+
+// drain stops the shard's maintenance, then the pipeline in order: spill
+// feeder, ring, validators, writer, quarantine, store, DB (flattened first when
+// configured and time is left). Pushes to the ring fail once it starts. If
+// ctx expires first, whatever is still queued is counted as lost and the
+// store and DB are left open under the remaining goroutines; the WAL, if any,
+// replays their events on the next start.
+func (s *Shard) drain(ctx context.Context) (DrainStats, error) {
+	start := s.persisted.Load()
+	close(s.quit)
+	s.bg.Wait()
+	_ = s.Spill.Close()
+	_ = s.WAL.Close() // stops replay; settling continues in memory
+	s.Ring.Close()
+	go func() { s.validators.Wait(); close(s.ValOut) }()
+	var st DrainStats
+	select {
+	case <-s.done:
+		// validators are gone, so nothing can Put to the quarantine any more
+		s.Quarantine.Close()
+		_ = s.WAL.Checkpoint(s.DB)
+		if s.flatten && ctx.Err() == nil { _ = s.DB.Flatten(2) }
+	case <-ctx.Done():
+		st.Lost = int64(s.Ring.Len() + len(s.ValOut))
+		st.Drained = s.persisted.Load() - start
+		return st, ctx.Err()
+	}
+	st.Drained = s.persisted.Load() - start
+	if err := s.Store.Close(); err != nil { _ = s.DB.Close(); return st, err }
+	return st, s.DB.Close()
+}
+
+// drainAll drains shards in parallel and sums their stats.
+func drainAll(ctx context.Context, shards []*Shard) (DrainStats, error) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		total DrainStats
+		first error
+	)
+	for _, s := range shards {
+		wg.Add(1)
+		go func(s *Shard) {
+			defer wg.Done()
+			st, err := s.drain(ctx)
+			mu.Lock(); defer mu.Unlock()
+			total.Drained += st.Drained
+			total.Lost += st.Lost
+			if err != nil && first == nil { first = err }
+		}(s)
+	}
+	wg.Wait()
+	return total, first
+}
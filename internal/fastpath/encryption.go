@@ -0,0 +1,80 @@
+package fastpath
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// EncryptionOptions turns on Badger's encryption at rest. Key is the AES
+// master key (16, 24 or 32 bytes); it encrypts the data keys, which encrypt
+// the SSTs and value log and are replaced every DataKeyRotation. Nil Key
+// stores plaintext.
+type EncryptionOptions struct {
+	Key             []byte
+	DataKeyRotation time.Duration // default 10 days
+}
+
+const DefaultDataKeyRotation = 10 * 24 * time.Hour
+
+// ParseEncryptionKey decodes a hex or base64 master key.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	if s == "" { return nil, nil }
+	k, err := hex.DecodeString(s)
+	if err != nil { k, err = base64.StdEncoding.DecodeString(s) }
+	if err != nil { return nil, errors.New("encryption key must be hex or base64") }
+	switch len(k) {
+	case 16, 24, 32:
+		return k, nil
+	}
+	return nil, fmt.Errorf("encryption key is %d bytes, want 16, 24 or 32", len(k))
+}
+
+func (e EncryptionOptions) apply(opts badger.Options) badger.Options {
+	if len(e.Key) == 0 { return opts }
+	if e.DataKeyRotation <= 0 { e.DataKeyRotation = DefaultDataKeyRotation }
+	// Badger refuses encryption without an index cache; OpenBadger sets one.
+	return opts.WithEncryptionKey(e.Key).WithEncryptionKeyRotationDuration(e.DataKeyRotation)
+}
+
+// Rekey moves the shard DB in dir from oldKey to newKey while it is closed.
+// Between two keys only the key registry is rewritten, since the master key
+// encrypts nothing but the data keys. Turning encryption on or off rewrites
+// every value into a fresh DB that then replaces dir.
+func Rekey(dir string, oldKey, newKey []byte) error {
+	if len(oldKey) > 0 && len(newKey) > 0 {
+		kro := badger.KeyRegistryOptions{Dir: dir, ReadOnly: true, EncryptionKey: oldKey, EncryptionKeyRotationDuration: DefaultDataKeyRotation}
+		kr, err := badger.OpenKeyRegistry(kro)
+		if err != nil { return err }
+		defer kr.Close()
+		kro.EncryptionKey = newKey
+		return badger.WriteKeyRegistry(kr, kro)
+	}
+	if len(oldKey) == 0 && len(newKey) == 0 { return nil }
+	src, err := OpenBadger(dir, EncryptionOptions{Key: oldKey}, CompressionOptions{})
+	if err != nil { return err }
+	tmp := filepath.Clean(dir) + ".rekey"
+	if err := os.RemoveAll(tmp); err != nil { _ = src.Close(); return err }
+	dst, err := OpenBadger(tmp, EncryptionOptions{Key: newKey}, CompressionOptions{})
+	if err != nil { _ = src.Close(); return err }
+	pr, pw := io.Pipe()
+	backup := make(chan error, 1)
+	go func() { _, err := src.Backup(pw, 0); pw.CloseWithError(err); backup <- err }()
+	err = dst.Load(pr, 256)
+	_ = pr.CloseWithError(io.ErrClosedPipe) // unblocks Backup if Load failed
+	if berr := <-backup; err == nil { err = berr }
+	if cerr := src.Close(); err == nil { err = cerr }
+	if cerr := dst.Close(); err == nil { err = cerr }
+	if err != nil { _ = os.RemoveAll(tmp); return err }
+	old := filepath.Clean(dir) + ".old"
+	if err := os.Rename(dir, old); err != nil { return err }
+	if err := os.Rename(tmp, dir); err != nil { _ = os.Rename(old, dir); return err }
+	return os.RemoveAll(old)
+}
@@ -0,0 +1,116 @@
+package fastpath
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"webhook-engine/pkg/metrics"
+)
+
+// GCOptions configures a shard's value-log GC loop.
+type GCOptions struct {
+	DiscardRatio float64       // rewrite a value-log file once this share of it is stale (default 0.5)
+	Interval     time.Duration // default 5m
+	// Window limits runs to a daily local-time range; From == To allows any
+	// time. From > To wraps midnight.
+	Window  ClockWindow
+	Flatten bool // compact the LSM tree into one level on shutdown
+}
+
+const (
+	DefaultGCInterval     = 5 * time.Minute
+	DefaultGCDiscardRatio = 0.5
+)
+
+// ClockWindow is a daily time range in minutes after local midnight.
+type ClockWindow struct{ From, To int }
+
+// ParseClockWindow parses "HH:MM-HH:MM"; an empty string is the whole day.
+func ParseClockWindow(s string) (ClockWindow, error) {
+	if s == "" { return ClockWindow{}, nil }
+	var h1, m1, h2, m2 int
+	if _, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2); err != nil || h1 > 23 || h2 > 23 || m1 > 59 || m2 > 59 {
+		return ClockWindow{}, fmt.Errorf("window %q: want HH:MM-HH:MM", s)
+	}
+	return ClockWindow{From: h1*60 + m1, To: h2*60 + m2}, nil
+}
+
+func (w ClockWindow) Contains(t time.Time) bool {
+	if w.From == w.To { return true }
+	m := t.Hour()*60 + t.Minute()
+	if w.From < w.To { return m >= w.From && m < w.To }
+	return m >= w.From || m < w.To
+}
+
+// GCStatus describes a shard's GC loop for the admin API.
+type GCStatus struct {
+	Paused        bool      `json:"paused"`
+	LastRun       time.Time `json:"last_run,omitempty"`
+	LastDuration  string    `json:"last_duration,omitempty"`
+	LastReclaimed int64     `json:"last_reclaimed_bytes"`
+	Rewrites      int       `json:"last_rewrites"`
+}
+
+type gcState struct {
+	paused atomic.Bool
+	mu     sync.Mutex
+	last   GCStatus
+}
+
+// PauseGC stops the shard's GC loop from starting new runs; a run in
+// progress finishes.
+func (s *Shard) PauseGC(paused bool) { s.gc.paused.Store(paused) }
+
+func (s *Shard) GCStatus() GCStatus {
+	s.gc.mu.Lock(); defer s.gc.mu.Unlock()
+	st := s.gc.last
+	st.Paused = s.gc.paused.Load()
+	return st
+}
+
+// collect runs value-log GC every o.Interval while inside the window and
+// not paused, until s.quit closes.
+func (s *Shard) collect(o GCOptions, route, shard string) {
+	defer s.bg.Done()
+	if o.Interval <= 0 { o.Interval = DefaultGCInterval }
+	if o.DiscardRatio <= 0 || o.DiscardRatio >= 1 { o.DiscardRatio = DefaultGCDiscardRatio }
+	t := time.NewTicker(o.Interval); defer t.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-t.C:
+			if s.gc.paused.Load() || !o.Window.Contains(now) {
+				metrics.GCRuns.WithLabelValues(route, shard, "skipped").Inc()
+				continue
+			}
+			reclaimed, rewrites, took := s.valueLogGC(o.DiscardRatio)
+			metrics.GCRuns.WithLabelValues(route, shard, "ran").Inc()
+			metrics.GCDuration.WithLabelValues(route, shard).Observe(took.Seconds())
+			metrics.ReclaimedBytes.WithLabelValues(route, shard).Add(float64(reclaimed))
+			s.gc.mu.Lock()
+			s.gc.last = GCStatus{LastRun: now, LastDuration: took.String(), LastReclaimed: reclaimed, Rewrites: rewrites}
+			s.gc.mu.Unlock()
+		}
+	}
+}
+
+// valueLogGC rewrites value-log files until none passes discard and returns
+// the bytes the value log shrank by.
+func (s *Shard) valueLogGC(discard float64) (reclaimed int64, rewrites int, took time.Duration) {
+	start := time.Now()
+	_, before := s.DiskSize()
+	for s.DB.RunValueLogGC(discard) == nil {
+		rewrites++
+		select {
+		case <-s.quit:
+			return 0, rewrites, time.Since(start)
+		default:
+		}
+	}
+	_, after := s.DiskSize()
+	if after < before { reclaimed = before - after }
+	return reclaimed, rewrites, time.Since(start)
+}
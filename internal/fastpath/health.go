@@ -0,0 +1,65 @@
+package fastpath
+
+import (
+	"sync/atomic"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// UnhealthyAfter is the number of consecutive failed flushes after which a
+// shard is marked unhealthy and stops taking new events.
+const UnhealthyAfter = 3
+
+// Probe pacing for an unhealthy shard: the wait after a failed probe starts
+// at probeMin and doubles up to probeMax.
+const (
+	probeMin = 100 * time.Millisecond
+	probeMax = 5 * time.Second
+)
+
+// health tracks a shard's write failures. An unhealthy shard gets no traffic,
+// so the writer probes the DB on idle ticks, backing off between failed
+// probes, until a write succeeds.
+type health struct {
+	failures atomic.Int32
+	down     atomic.Bool
+	errors   atomic.Int64 // failed writes since start
+
+	// writer goroutine only
+	probeAt   time.Time
+	probeWait time.Duration
+}
+
+func (h *health) record(err error) {
+	if err == nil {
+		h.failures.Store(0)
+		h.down.Store(false)
+		h.probeAt, h.probeWait = time.Time{}, 0
+		return
+	}
+	h.errors.Add(1)
+	if h.failures.Add(1) >= UnhealthyAfter { h.down.Store(true) }
+}
+
+// probe writes a marker key so a shard that lost its disk notices recovery.
+// It does nothing until the backoff from the last failed probe has passed.
+func (h *health) probe(db *badger.DB) error {
+	now := time.Now()
+	if now.Before(h.probeAt) { return nil }
+	err := db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte{PrefixHealth}, now.AppendFormat(nil, time.RFC3339Nano))
+	})
+	h.record(err)
+	if err != nil {
+		h.probeWait = min(max(2*h.probeWait, probeMin), probeMax)
+		h.probeAt = now.Add(h.probeWait)
+	}
+	return err
+}
+
+// Healthy reports whether the shard's last writes succeeded.
+func (s *Shard) Healthy() bool { return !s.health.down.Load() }
+
+// WriteErrors is the number of failed Badger writes since the shard opened.
+func (s *Shard) WriteErrors() int64 { return s.health.errors.Load() }
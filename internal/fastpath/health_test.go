@@ -0,0 +1,20 @@
+package fastpath
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeBacksOff(t *testing.T) {
+	db := memDB(t)
+	db.Close() // every write fails
+	var h health
+	if h.probe(db) == nil { t.Fatal("probe of a closed DB succeeded") }
+	if h.probe(db) != nil || h.errors.Load() != 1 { t.Fatalf("probed again within the backoff, %d errors", h.errors.Load()) }
+
+	h.probeAt = time.Now()
+	h.probe(db)
+	if h.probeWait != 2*probeMin { t.Fatalf("wait %v after two failures", h.probeWait) }
+	h.record(nil)
+	if h.probeWait != 0 || !h.probeAt.IsZero() { t.Fatal("recovery kept the backoff") }
+}
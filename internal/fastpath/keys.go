@@ -0,0 +1,63 @@
+package fastpath
+
+import "encoding/binary"
+
+// Keyspaces sharing a shard's Badger DB; every key starts with one of these.
+const (
+	PrefixEvent      byte = 'e'
+	PrefixQuarantine byte = 'q'
+	PrefixCursor     byte = 'c' // consumer group name -> committed event key
+	PrefixDelivery   byte = 'd' // group \x00 event key -> delivery attempts
+	PrefixDead       byte = 'x'
+	PrefixRedeliver  byte = 'r' // group \x00 event key -> envelope requeued from the DLQ
+	PrefixHealth     byte = 'h' // write probe of an unhealthy shard
+	PrefixWAL        byte = 'w' // WAL watermark: last settled sequence
+	PrefixIdem       byte = 'i' // idempotency key -> event key, expires with the dedupe window
+	PrefixDict       byte = 'z' // BE dictionary ID -> zstd dictionary of the value codec
+)
+
+// Time-ordered keys are prefix | receive time (unix ns, big endian) |
+// per-shard sequence, so byte order is arrival order and a key doubles as a
+// resumable cursor.
+const OrderedKeyLen = 13
+
+func orderedKey(prefix byte, ns int64, seq uint32) []byte {
+	k := make([]byte, OrderedKeyLen)
+	k[0] = prefix
+	binary.BigEndian.PutUint64(k[1:], uint64(ns))
+	binary.BigEndian.PutUint32(k[9:], seq)
+	return k
+}
+
+// EventKey is the storage key of the event received at ns with sequence seq.
+func EventKey(ns int64, seq uint32) []byte { return orderedKey(PrefixEvent, ns, seq) }
+
+// ParseKey splits a time-ordered key into its receive time and sequence.
+func ParseKey(k []byte) (ns int64, seq uint32, ok bool) {
+	if len(k) != OrderedKeyLen { return 0, 0, false }
+	return int64(binary.BigEndian.Uint64(k[1:])), binary.BigEndian.Uint32(k[9:]), true
+}
+
+// keyGen hands out strictly increasing keys for one keyspace. A receive time
+// at or behind the last one reuses it and bumps the sequence instead, so
+// validator reordering or a clock step back never breaks key order.
+type keyGen struct {
+	prefix byte
+	last   int64
+	seq    uint32
+}
+
+func (g *keyGen) next(ns int64) []byte {
+	if ns > g.last {
+		g.last, g.seq = ns, 0
+	} else {
+		g.seq++
+		if g.seq == 0 { g.last++ } // sequence wrapped; borrow the next nanosecond
+	}
+	return orderedKey(g.prefix, g.last, g.seq)
+}
+
+// seed continues after the newest existing key k.
+func (g *keyGen) seed(k []byte) {
+	if ns, seq, ok := ParseKey(k); ok { g.last, g.seq = ns, seq }
+}
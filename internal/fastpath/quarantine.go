@@ -0,0 +1,189 @@
+package fastpath
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/metrics"
+)
+
+type QuarantineOptions struct {
+	Enabled  bool
+	TTL      time.Duration
+	MaxBytes int64
+}
+
+// QuarantineEntry is an event that failed verification, kept for forensics
+// and for replay once the route's secret is fixed.
+type QuarantineEntry struct {
+	Key         []byte            `json:"-"`
+	ReceivedAt  int64             `json:"received_at"`
+	Reason      string            `json:"reason"`
+	Hdrs        []string          `json:"headers"`
+	Body        []byte            `json:"body"`
+	RemoteIP    string            `json:"remote_ip,omitempty"`
+	Traceparent string            `json:"traceparent,omitempty"`
+	Kept        map[string]string `json:"kept_headers,omitempty"`
+}
+
+// Event rebuilds the queue event the entry was captured from.
+func (q QuarantineEntry) Event() fastqueue.Event {
+	hdrs := make([][]byte, len(q.Hdrs))
+	for i, h := range q.Hdrs {
+		hdrs[i] = []byte(h)
+	}
+	return fastqueue.Event{Body: q.Body, Hdrs: hdrs, Recv: q.ReceivedAt, Remote: q.RemoteIP, Trace: q.Traceparent, Kept: q.Kept}
+}
+
+// Quarantine stores rejected events under PrefixQuarantine in the shard DB.
+// Entries expire after TTL; once MaxBytes is exceeded the oldest are evicted
+// down to 90% of it, so a flood does not evict on every write.
+// Writes go through a buffered channel so a flood of bad requests cannot
+// stall validators; entries that don't fit are counted and dropped.
+type Quarantine struct {
+	db    *badger.DB
+	opts  QuarantineOptions
+	in    chan QuarantineEntry
+	usage *eventBytes // value bytes of the live entries
+	once  sync.Once
+	done  chan struct{}
+}
+
+func NewQuarantine(db *badger.DB, o QuarantineOptions) (*Quarantine, error) {
+	q := &Quarantine{db: db, opts: o, in: make(chan QuarantineEntry, 1024), done: make(chan struct{})}
+	var err error
+	if q.usage, err = q.size(); err != nil { return nil, err }
+	go q.run()
+	return q, nil
+}
+
+// Put queues e for quarantine. It never blocks and is a no-op on a nil
+// Quarantine.
+func (q *Quarantine) Put(e fastqueue.Event, reason string) {
+	if q == nil { return }
+	hdrs := make([]string, len(e.Hdrs))
+	for i, h := range e.Hdrs {
+		hdrs[i] = string(h)
+	}
+	select {
+	case q.in <- QuarantineEntry{ReceivedAt: e.Recv, Reason: reason, Hdrs: hdrs, Body: e.Body,
+		RemoteIP: e.Remote, Traceparent: e.Trace, Kept: e.Kept}:
+		metrics.QuarantinedTotal.WithLabelValues(reason).Inc()
+	default:
+		metrics.QuarantineDropped.Inc()
+	}
+}
+
+func (q *Quarantine) Close() {
+	if q == nil { return }
+	q.once.Do(func() { close(q.in) })
+	<-q.done
+}
+
+func (q *Quarantine) run() {
+	defer close(q.done)
+	keys := keyGen{prefix: PrefixQuarantine}
+	if last, err := lastKey(q.db, PrefixQuarantine); err == nil { keys.seed(last) }
+	for e := range q.in {
+		k := keys.next(e.ReceivedAt)
+		v, err := json.Marshal(e)
+		if err != nil { continue }
+		ent := badger.NewEntry(k, v)
+		if q.opts.TTL > 0 { ent = ent.WithTTL(q.opts.TTL) }
+		if q.db.Update(func(txn *badger.Txn) error { return txn.SetEntry(ent) }) != nil { continue }
+		q.usage.add(k, int64(len(v)))
+		if q.opts.MaxBytes > 0 && q.usage.Total() > q.opts.MaxBytes { _ = q.evict() }
+	}
+}
+
+// size measures the value sizes of the live quarantine entries, once at
+// open; run keeps the count current after that.
+func (q *Quarantine) size() (*eventBytes, error) {
+	u := newEventBytes()
+	err := q.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixQuarantine}})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			u.add(it.Item().Key(), it.Item().ValueSize())
+		}
+		return nil
+	})
+	return u, err
+}
+
+// evict deletes the oldest entries until they fit 90% of MaxBytes. Minutes
+// past the TTL are dropped from the count first, as those entries expired
+// without passing through here.
+func (q *Quarantine) evict() error {
+	if q.opts.TTL > 0 { q.usage.dropBefore(time.Now().Add(-q.opts.TTL)) }
+	n, low := q.usage.Total(), q.opts.MaxBytes/10*9
+	if n <= q.opts.MaxBytes { return nil }
+	wb := q.db.NewWriteBatch(); defer wb.Cancel()
+	var keys [][]byte
+	var sizes []int64
+	exhausted := false
+	err := q.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixQuarantine}})
+		defer it.Close()
+		for it.Rewind(); it.Valid() && n > low; it.Next() {
+			k, size := it.Item().KeyCopy(nil), it.Item().ValueSize()
+			if err := wb.Delete(k); err != nil { return err }
+			keys, sizes = append(keys, k), append(sizes, size)
+			n -= size
+		}
+		exhausted = !it.Valid()
+		return nil
+	})
+	if err != nil { return err }
+	if err := wb.Flush(); err != nil { return err }
+	if exhausted {
+		// the count ran ahead of what is stored (entries expired early)
+		q.usage.reset()
+		return nil
+	}
+	for i, k := range keys {
+		q.usage.add(k, -sizes[i])
+	}
+	return nil
+}
+
+// List returns up to limit entries, oldest first, starting after key after.
+func (q *Quarantine) List(after []byte, limit int) ([]QuarantineEntry, error) {
+	var out []QuarantineEntry
+	err := q.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{PrefixQuarantine}, PrefetchValues: true})
+		defer it.Close()
+		it.Seek([]byte{PrefixQuarantine})
+		if len(after) > 0 {
+			it.Seek(after)
+			if it.Valid() && string(it.Item().Key()) == string(after) { it.Next() }
+		}
+		for ; it.Valid() && len(out) < limit; it.Next() {
+			var e QuarantineEntry
+			err := it.Item().Value(func(v []byte) error { return json.Unmarshal(v, &e) })
+			if err != nil { return err }
+			e.Key = it.Item().KeyCopy(nil)
+			out = append(out, e)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (q *Quarantine) Delete(key []byte) error {
+	var size int64
+	err := q.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) { return nil }
+		if err != nil { return err }
+		size = item.ValueSize()
+		return txn.Delete(key)
+	})
+	if err == nil { q.usage.add(key, -size) }
+	return err
+}
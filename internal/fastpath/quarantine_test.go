@@ -0,0 +1,45 @@
+package fastpath
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"webhook-engine/pkg/fastqueue"
+)
+
+func memDB(t *testing.T) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQuarantineEvictsToLowWater(t *testing.T) {
+	db := memDB(t)
+	q, err := NewQuarantine(db, QuarantineOptions{Enabled: true, MaxBytes: 20000})
+	if err != nil { t.Fatal(err) }
+	body := bytes.Repeat([]byte("x"), 900)
+	now := time.Now().UnixNano()
+	for i := 0; i < 50; i++ {
+		for len(q.in) == cap(q.in) {
+			time.Sleep(time.Millisecond)
+		}
+		q.Put(fastqueue.Event{Body: body, Recv: now + int64(i)}, "signature")
+	}
+	q.Close()
+
+	stored, err := q.size()
+	if err != nil { t.Fatal(err) }
+	if got, want := q.usage.Total(), stored.Total(); got != want { t.Fatalf("tracked %d bytes, stored %d", got, want) }
+	if stored.Total() > 20000 { t.Fatalf("%d bytes stored past MaxBytes", stored.Total()) }
+
+	entries, err := q.List(nil, 100)
+	if err != nil { t.Fatal(err) }
+	if len(entries) == 0 || entries[len(entries)-1].ReceivedAt != now+49 { t.Fatal("newest entry was evicted") }
+	if err := q.Delete(entries[0].Key); err != nil { t.Fatal(err) }
+	if after := q.usage.Total(); after >= stored.Total() { t.Fatalf("Delete left the count at %d", after) }
+}
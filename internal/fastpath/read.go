@@ -0,0 +1,11 @@
+package fastpath
+
+// Get returns the stored event under key, or ErrNotFound.
+func (s *Shard) Get(key []byte) ([]byte, error) { return s.Store.Get(key) }
+
+// Scan calls fn for every stored event with from <= key < to, in key (and
+// so arrival) order, until fn returns false. A nil to scans to the end. The
+// slices passed to fn are only valid during the call.
+func (s *Shard) Scan(from, to []byte, fn func(key, val []byte) bool) error {
+	return s.Store.Scan(from, to, fn)
+}
@@ -0,0 +1,179 @@
+package fastpath
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"webhook-engine/pkg/metrics"
+)
+
+// RetentionOptions bounds how much of its event keyspace a shard keeps.
+// Zero limits keep everything. Freed value-log space comes back through the
+// GC loop (see GCOptions).
+type RetentionOptions struct {
+	MaxAge   time.Duration // events are written with this TTL and swept by receive time
+	MaxBytes int64         // estimated size of the stored events per shard
+	Interval time.Duration // sweep period (default 1m)
+}
+
+const (
+	DefaultRetentionInterval = time.Minute
+	sweepChunk               = 10000
+)
+
+// sweep runs the retention sweep until s.quit closes.
+func (s *Shard) sweep(o RetentionOptions, route string) {
+	defer s.bg.Done()
+	if o.Interval <= 0 { o.Interval = DefaultRetentionInterval }
+	t := time.NewTicker(o.Interval); defer t.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-t.C:
+			if o.MaxAge > 0 {
+				n, _ := s.expire(now.Add(-o.MaxAge))
+				s.usage.dropBefore(now.Add(-o.MaxAge))
+				metrics.RetentionDeleted.WithLabelValues(route, "age").Add(float64(n))
+			}
+			if o.MaxBytes > 0 {
+				n, _ := s.trim(o.MaxBytes)
+				metrics.RetentionDeleted.WithLabelValues(route, "size").Add(float64(n))
+			}
+		}
+	}
+}
+
+// expire deletes the events received before cutoff. Keys are time-ordered,
+// so this walks from the oldest event and stops at the first newer one;
+// TTLs do the same for new writes, this covers events stored without one.
+func (s *Shard) expire(cutoff time.Time) (int, error) {
+	end := EventKey(cutoff.UnixNano(), 0)
+	return s.deleteOldest(func(key []byte, _ int64) bool { return string(key) < string(end) })
+}
+
+// trim deletes the oldest events until the tracked size of the event
+// keyspace (keys plus values) fits max.
+func (s *Shard) trim(max int64) (int, error) {
+	total := s.usage.Total()
+	if total <= max { return 0, nil }
+	return s.deleteOldest(func(_ []byte, size int64) bool {
+		if total <= max { return false }
+		total -= size
+		return true
+	})
+}
+
+// deleteOldest deletes events from the oldest on while more returns true,
+// in chunks so a large backlog never sits in one transaction.
+func (s *Shard) deleteOldest(more func(key []byte, size int64) bool) (int, error) {
+	deleted := 0
+	for {
+		var keys [][]byte
+		var sizes []int64
+		done := true
+		err := s.Store.Scan(nil, nil, func(key, val []byte) bool {
+			if len(keys) == sweepChunk { done = false; return false }
+			size := int64(len(key) + len(val))
+			if !more(key, size) { return false }
+			keys, sizes = append(keys, append([]byte(nil), key...)), append(sizes, size)
+			return true
+		})
+		if err != nil || len(keys) == 0 { return deleted, err }
+		if err := s.Store.Delete(keys...); err != nil { return deleted, err }
+		for i, k := range keys {
+			s.usage.add(k, -sizes[i])
+		}
+		deleted += len(keys)
+		if done { return deleted, nil }
+	}
+}
+
+// eventBytes tracks the size of a time-ordered keyspace (the shard's events,
+// or its quarantine) per minute of receive time, so retention and eviction
+// know it without reading it. Entries that expire through their TTL are
+// never seen again; dropping the minutes past the age cutoff accounts for
+// them. A nil eventBytes tracks nothing.
+type eventBytes struct {
+	mu      sync.Mutex
+	minutes map[int64]int64 // unix minute -> bytes
+	total   int64
+}
+
+// countEvents sizes the events already in st, once at open.
+func countEvents(st Store) (*eventBytes, error) {
+	b := newEventBytes()
+	err := st.Scan(nil, nil, func(key, val []byte) bool {
+		b.add(key, int64(len(key)+len(val)))
+		return true
+	})
+	return b, err
+}
+
+func newEventBytes() *eventBytes { return &eventBytes{minutes: map[int64]int64{}} }
+
+func (b *eventBytes) add(key []byte, n int64) {
+	if b == nil { return }
+	ns, _, _ := ParseKey(key)
+	m := ns / int64(time.Minute)
+	b.mu.Lock()
+	b.minutes[m] += n
+	b.total += n
+	b.mu.Unlock()
+}
+
+// dropBefore forgets the minutes that ended before cutoff.
+func (b *eventBytes) dropBefore(cutoff time.Time) {
+	if b == nil { return }
+	end := cutoff.UnixNano() / int64(time.Minute)
+	b.mu.Lock()
+	for m, n := range b.minutes {
+		if m < end { b.total -= n; delete(b.minutes, m) }
+	}
+	b.mu.Unlock()
+}
+
+func (b *eventBytes) reset() {
+	b.mu.Lock()
+	clear(b.minutes)
+	b.total = 0
+	b.mu.Unlock()
+}
+
+func (b *eventBytes) Total() int64 {
+	if b == nil { return 0 }
+	b.mu.Lock(); defer b.mu.Unlock()
+	return b.total
+}
+
+// sizedStore counts what is appended to the Store it wraps.
+type sizedStore struct {
+	Store
+	usage *eventBytes
+}
+
+func (s sizedStore) AppendBatch(kvs []KV, ttl time.Duration) error {
+	if err := s.Store.AppendBatch(kvs, ttl); err != nil { return err }
+	for _, kv := range kvs {
+		s.usage.add(kv.Key, int64(len(kv.Key)+len(kv.Value)))
+	}
+	return nil
+}
+
+// DiskSize returns the bytes of the shard's LSM tables and value log files.
+// Unlike DB.Size it stats the files, so it is current right after a GC.
+func (s *Shard) DiskSize() (lsm, vlog int64) {
+	opts := s.DB.Opts()
+	return globSize(filepath.Join(opts.Dir, "*.sst")), globSize(filepath.Join(opts.ValueDir, "*.vlog"))
+}
+
+func globSize(pattern string) int64 {
+	names, _ := filepath.Glob(pattern)
+	var n int64
+	for _, name := range names {
+		if st, err := os.Stat(name); err == nil { n += st.Size() }
+	}
+	return n
+}
@@ -0,0 +1,24 @@
+package fastpath
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBytes(t *testing.T) {
+	b := newEventBytes()
+	t0 := time.Date(2026, 10, 1, 12, 0, 30, 0, time.UTC)
+	b.add(EventKey(t0.UnixNano(), 0), 100)
+	b.add(EventKey(t0.Add(time.Minute).UnixNano(), 0), 200)
+	b.add(EventKey(t0.Add(2*time.Minute).UnixNano(), 0), 400)
+	if got := b.Total(); got != 700 { t.Fatalf("total %d", got) }
+
+	b.add(EventKey(t0.Add(2*time.Minute).UnixNano(), 0), -400)
+	// the cutoff's own minute is kept until it has fully passed
+	b.dropBefore(t0.Add(time.Minute))
+	if got := b.Total(); got != 200 { t.Fatalf("total %d after drop", got) }
+
+	var none *eventBytes
+	none.add(EventKey(t0.UnixNano(), 0), 1)
+	if none.Total() != 0 { t.Fatal("nil eventBytes counted") }
+}
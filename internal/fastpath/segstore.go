@@ -0,0 +1,290 @@
+package fastpath
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSegmentBytes = 64 << 20
+
+const (
+	segPut byte = iota
+	segDel
+)
+
+// segStore is an append-only event log in segment files of about
+// SegmentBytes each, for routes that write once and read in order. Records
+// are uint32 length | uint32 CRC-32C | kind | uint16 key length | key |
+// value, the CRC covering everything after it. A batch is one write and one
+// fsync. Deletes append tombstones; the oldest segment is removed once
+// nothing in it is live, and removal only ever goes oldest first so a
+// tombstone never outlives the segment it refers to.
+//
+// The index of live events is kept in memory, sorted by key, and rebuilt
+// from the segments on open.
+type segStore struct {
+	dir   string
+	o     StoreOptions
+	mu    sync.RWMutex
+	files map[uint32]*os.File
+	order []uint32         // segment ids, oldest first; the last one takes appends
+	live  map[uint32]int   // live events per segment
+	size  map[uint32]int64 // bytes per segment
+	index []segEntry
+}
+
+type segEntry struct {
+	key []byte
+	seg uint32
+	off int64 // value offset
+	n   uint32
+}
+
+func segName(id uint32) string { return fmt.Sprintf("%010d.seg", id) }
+
+// OpenSegmentStore opens or creates the segment log in dir. A torn or
+// corrupt record ends its segment; the tail behind it is cut off.
+func OpenSegmentStore(dir string, o StoreOptions) (*segStore, error) {
+	if o.SegmentBytes <= 0 { o.SegmentBytes = DefaultSegmentBytes }
+	if err := os.MkdirAll(dir, 0o700); err != nil { return nil, err }
+	s := &segStore{dir: dir, o: o, files: map[uint32]*os.File{}, live: map[uint32]int{}, size: map[uint32]int64{}}
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil { return nil, err }
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".seg"), 10, 32)
+		if err == nil { s.order = append(s.order, uint32(id)) }
+	}
+	sort.Slice(s.order, func(i, j int) bool { return s.order[i] < s.order[j] })
+	for _, id := range s.order {
+		if err := s.load(id); err != nil { s.Close(); return nil, err }
+	}
+	if len(s.order) == 0 {
+		if err := s.create(1); err != nil { return nil, err }
+	}
+	s.compact()
+	return s, nil
+}
+
+func (s *segStore) create(id uint32) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, segName(id)), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil { return err }
+	s.files[id], s.size[id] = f, 0
+	s.order = append(s.order, id)
+	return nil
+}
+
+// load indexes segment id.
+func (s *segStore) load(id uint32) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, segName(id)), os.O_RDWR, 0)
+	if err != nil { return err }
+	s.files[id] = f
+	st, err := f.Stat()
+	if err != nil { return err }
+	var off int64
+	hdr := make([]byte, 8)
+	for {
+		_, err := f.ReadAt(hdr, off)
+		var b []byte
+		if err == nil && int64(binary.BigEndian.Uint32(hdr)) > st.Size()-off-8 { err = io.ErrUnexpectedEOF }
+		if err == nil {
+			b = make([]byte, binary.BigEndian.Uint32(hdr))
+			_, err = f.ReadAt(b, off+8)
+			if err == nil && (len(b) < 3 || crc32.Checksum(b, walTable) != binary.BigEndian.Uint32(hdr[4:])) { err = io.ErrUnexpectedEOF }
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			s.size[id] = off
+			return f.Truncate(off)
+		}
+		if err != nil { return err }
+		kl := int(binary.BigEndian.Uint16(b[1:]))
+		if 3+kl > len(b) { s.size[id] = off; return f.Truncate(off) }
+		key := append([]byte(nil), b[3:3+kl]...) // b also holds the value
+		switch b[0] {
+		case segPut:
+			s.insert(segEntry{key: key, seg: id, off: off + 8 + 3 + int64(kl), n: uint32(len(b) - 3 - kl)})
+		case segDel:
+			s.remove(key)
+		}
+		off += 8 + int64(len(b))
+	}
+}
+
+// insert adds e in key order; appends are the common case.
+func (s *segStore) insert(e segEntry) {
+	s.live[e.seg]++
+	if n := len(s.index); n == 0 || bytes.Compare(s.index[n-1].key, e.key) < 0 {
+		s.index = append(s.index, e); return
+	}
+	i := s.search(e.key)
+	if i < len(s.index) && bytes.Equal(s.index[i].key, e.key) {
+		s.live[s.index[i].seg]--
+		s.index[i] = e
+		return
+	}
+	s.index = append(s.index, segEntry{})
+	copy(s.index[i+1:], s.index[i:])
+	s.index[i] = e
+}
+
+// remove drops key from the index during load.
+func (s *segStore) remove(key []byte) {
+	i := s.search(key)
+	if i == len(s.index) || !bytes.Equal(s.index[i].key, key) { return }
+	s.live[s.index[i].seg]--
+	s.index = append(s.index[:i:i], s.index[i+1:]...)
+}
+
+func (s *segStore) search(key []byte) int {
+	return sort.Search(len(s.index), func(i int) bool { return bytes.Compare(s.index[i].key, key) >= 0 })
+}
+
+// compact removes leading segments without live events. s.mu must be held.
+func (s *segStore) compact() {
+	for len(s.order) > 1 && s.live[s.order[0]] == 0 {
+		id := s.order[0]
+		_ = s.files[id].Close()
+		if err := os.Remove(filepath.Join(s.dir, segName(id))); err != nil && !errors.Is(err, os.ErrNotExist) { return }
+		delete(s.files, id); delete(s.live, id); delete(s.size, id)
+		s.order = s.order[1:]
+	}
+}
+
+func segRecord(buf []byte, kind byte, key, val []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, 0, 0, 0, 0, kind)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(key)))
+	buf = append(append(buf, key...), val...)
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(buf)-start-8))
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.Checksum(buf[start+8:], walTable))
+	return buf
+}
+
+// write appends buf to the current segment, rotating first when it is full,
+// and returns the segment and offset it landed at. On error the segment is
+// cut back so a retry never follows a torn record. s.mu must be held.
+func (s *segStore) write(buf []byte) (uint32, int64, error) {
+	id := s.order[len(s.order)-1]
+	if s.size[id] > 0 && s.size[id]+int64(len(buf)) > s.o.SegmentBytes {
+		if err := s.create(id + 1); err != nil { return 0, 0, err }
+		id++
+	}
+	f, off := s.files[id], s.size[id]
+	_, err := f.WriteAt(buf, off)
+	if err == nil && !s.o.NoSync { err = f.Sync() }
+	if err != nil { _ = f.Truncate(off); return 0, 0, err }
+	s.size[id] = off + int64(len(buf))
+	return id, off, nil
+}
+
+func (s *segStore) AppendBatch(kvs []KV, _ time.Duration) error {
+	if len(kvs) == 0 { return nil }
+	var buf []byte
+	rel := make([]int64, len(kvs)) // value offset within buf
+	for i, kv := range kvs {
+		buf = segRecord(buf, segPut, kv.Key, kv.Value)
+		rel[i] = int64(len(buf) - len(kv.Value))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, off, err := s.write(buf)
+	if err != nil { return err }
+	for i, kv := range kvs {
+		s.insert(segEntry{key: append([]byte(nil), kv.Key...), seg: id, off: off + rel[i], n: uint32(len(kv.Value))})
+	}
+	return nil
+}
+
+func (s *segStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	i := s.search(key)
+	if i == len(s.index) || !bytes.Equal(s.index[i].key, key) { s.mu.RUnlock(); return nil, ErrNotFound }
+	e, f := s.index[i], s.files[s.index[i].seg]
+	s.mu.RUnlock()
+	v := make([]byte, e.n)
+	if _, err := f.ReadAt(v, e.off); err != nil { return nil, err }
+	return v, nil
+}
+
+// Scan reads from a snapshot of the index so appends are never blocked;
+// events deleted meanwhile may still be returned or skipped.
+func (s *segStore) Scan(from, to []byte, fn func(key, val []byte) bool) error {
+	s.mu.RLock()
+	snap := s.index[s.search(from):]
+	s.mu.RUnlock()
+	var v []byte
+	for _, e := range snap {
+		if len(to) > 0 && bytes.Compare(e.key, to) >= 0 { return nil }
+		s.mu.RLock()
+		f := s.files[e.seg]
+		s.mu.RUnlock()
+		if f == nil { continue } // segment removed behind the snapshot
+		if cap(v) < int(e.n) { v = make([]byte, e.n) }
+		v = v[:e.n]
+		if _, err := f.ReadAt(v, e.off); err != nil {
+			if errors.Is(err, os.ErrClosed) { continue }
+			return err
+		}
+		if !fn(e.key, v) { return nil }
+	}
+	return nil
+}
+
+func (s *segStore) Delete(keys ...[]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	del := map[string]bool{}
+	var buf []byte
+	for _, k := range keys {
+		if i := s.search(k); i < len(s.index) && bytes.Equal(s.index[i].key, k) && !del[string(k)] {
+			del[string(k)] = true
+			buf = segRecord(buf, segDel, k, nil)
+		}
+	}
+	if len(del) == 0 { return nil }
+	if _, _, err := s.write(buf); err != nil { return err }
+	// copy, so running scans keep their snapshot
+	kept := make([]segEntry, 0, len(s.index)-len(del))
+	for _, e := range s.index {
+		if del[string(e.key)] { s.live[e.seg]--; continue }
+		kept = append(kept, e)
+	}
+	s.index = kept
+	s.compact()
+	return nil
+}
+
+func (s *segStore) Last() ([]byte, error) {
+	s.mu.RLock(); defer s.mu.RUnlock()
+	if len(s.index) == 0 { return nil, nil }
+	return append([]byte(nil), s.index[len(s.index)-1].key...), nil
+}
+
+// Size is the bytes of all segment files.
+func (s *segStore) Size() int64 {
+	s.mu.RLock(); defer s.mu.RUnlock()
+	var n int64
+	for _, sz := range s.size {
+		n += sz
+	}
+	return n
+}
+
+func (s *segStore) Close() error {
+	s.mu.Lock(); defer s.mu.Unlock()
+	var first error
+	for _, f := range s.files {
+		if err := f.Close(); err != nil && first == nil { first = err }
+	}
+	return first
+}
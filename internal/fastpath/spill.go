@@ -0,0 +1,206 @@
+package fastpath
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/validators"
+)
+
+type SpillOptions struct {
+	Enabled  bool
+	MaxBytes int64 // undrained bytes at which the handler falls back to 429
+	// Logged is set when the shard has a WAL: spilled events logged before
+	// the restart are unsettled in it and replayed from there, so the spill
+	// skips them instead of delivering them a second time.
+	Logged bool
+}
+
+const DefaultSpillBytes = 256 << 20
+
+// diskEvent is the on-disk form of a queue event in the spill log and the
+// WAL.
+type diskEvent struct {
+	Recv      int64             `json:"r"`
+	Verified  bool              `json:"v,omitempty"`
+	Check     string            `json:"c,omitempty"`
+	Source    string            `json:"s,omitempty"`
+	EventType string            `json:"t,omitempty"`
+	EventTS   int64             `json:"e,omitempty"`
+	ID        string            `json:"i,omitempty"`
+	WALSeq    uint64            `json:"w,omitempty"`
+	Remote    string            `json:"a,omitempty"`
+	Trace     string            `json:"p,omitempty"`
+	Kept      map[string]string `json:"k,omitempty"`
+	Hdrs      [][]byte          `json:"h"`
+	Body      []byte            `json:"b"`
+}
+
+func marshalDiskEvent(e fastqueue.Event) ([]byte, error) {
+	return json.Marshal(diskEvent{Recv: e.Recv, Verified: e.Verified, Check: e.Check, Source: e.Meta.Source, EventType: e.Meta.EventType, EventTS: e.Meta.EventTS,
+		ID: e.Meta.ID, WALSeq: e.WALSeq, Remote: e.Remote, Trace: e.Trace, Kept: e.Kept, Hdrs: e.Hdrs, Body: e.Body})
+}
+
+func unmarshalDiskEvent(b []byte) (fastqueue.Event, error) {
+	var d diskEvent
+	if err := json.Unmarshal(b, &d); err != nil { return fastqueue.Event{}, err }
+	return fastqueue.Event{Body: d.Body, Hdrs: d.Hdrs, Recv: d.Recv, Verified: d.Verified, Check: d.Check, WALSeq: d.WALSeq, Remote: d.Remote, Trace: d.Trace, Kept: d.Kept,
+		Meta: validators.Meta{Source: d.Source, EventType: d.EventType, EventTS: d.EventTS, ID: d.ID}}, nil
+}
+
+// Spill is a shard's overflow log, framed by a big-endian uint32 length per
+// record. Events that find the ring full are appended to it and a drain
+// goroutine feeds them back into the ring as room frees up. Once a quarter
+// of MaxBytes has been drained, new events go to a second file
+// (path+".next") that replaces the first when it is drained, so a backlog
+// that never empties still gives its space back; a drained file is
+// truncated. The files are reopened on start, so events spilled before a
+// crash are replayed (possibly twice, if they had already been drained),
+// unless Logged leaves that to the WAL.
+type Spill struct {
+	path   string
+	max    int64
+	ring   *fastqueue.Ring
+	mu     sync.Mutex
+	r, w   *os.File // drained and appended file; the same until a rotation
+	size   int64    // bytes appended to r
+	off    int64    // bytes drained from r
+	wsize  int64    // bytes appended to w while it is not r
+	logged bool
+	old    int64 // bytes of r from before open
+	wold   int64 // bytes of w from before open while it is not r
+	wake   chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func OpenSpill(path string, o SpillOptions, ring *fastqueue.Ring) (*Spill, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil { return nil, err }
+	st, err := f.Stat()
+	if err != nil { f.Close(); return nil, err }
+	if o.MaxBytes <= 0 { o.MaxBytes = DefaultSpillBytes }
+	s := &Spill{path: path, max: o.MaxBytes, ring: ring, r: f, w: f, size: st.Size(), logged: o.Logged, old: st.Size(),
+		wake: make(chan struct{}, 1), closed: make(chan struct{}), done: make(chan struct{})}
+	if st, err := os.Stat(path + ".next"); err == nil {
+		// crashed between rotations: drain path first, then .next
+		if s.w, err = os.OpenFile(path+".next", os.O_RDWR|os.O_APPEND, 0o600); err != nil { f.Close(); return nil, err }
+		s.wsize, s.wold = st.Size(), st.Size()
+	}
+	go s.drain()
+	return s, nil
+}
+
+// Pending is the number of spilled bytes not yet drained.
+func (s *Spill) Pending() int64 {
+	s.mu.Lock(); defer s.mu.Unlock()
+	return s.pending()
+}
+
+func (s *Spill) pending() int64 { return s.size - s.off + s.wsize }
+
+// Push appends e. It reports false when the undrained backlog would exceed
+// MaxBytes or the write fails.
+func (s *Spill) Push(e fastqueue.Event) bool {
+	b, err := marshalDiskEvent(e)
+	if err != nil { return false }
+	rec := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	rec = append(rec, b...)
+	s.mu.Lock()
+	if s.pending()+int64(len(rec)) > s.max { s.mu.Unlock(); return false }
+	if s.w == s.r && s.off >= s.max/4 {
+		// on failure keep appending to r; it is reclaimed once drained
+		if f, err := os.OpenFile(s.path+".next", os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0o600); err == nil { s.w = f }
+	}
+	n, err := s.w.Write(rec)
+	if s.w == s.r { s.size += int64(n) } else { s.wsize += int64(n) }
+	s.mu.Unlock()
+	if err != nil { return false }
+	select { case s.wake <- struct{}{}: default: }
+	return true
+}
+
+// reclaim frees the drained file r: it is replaced by the rotated one, or
+// truncated when there is none. Called with mu held, from drain only.
+func (s *Spill) reclaim() {
+	if s.w == s.r {
+		if s.size > 0 && s.r.Truncate(0) == nil { s.off, s.size, s.old = 0, 0, 0 }
+		return
+	}
+	if err := os.Rename(s.path+".next", s.path); err != nil { return } // retried on the next wake
+	_ = s.r.Close()
+	s.r, s.off, s.size, s.wsize = s.w, 0, s.wsize, 0
+	s.old, s.wold = s.wold, 0
+}
+
+func (s *Spill) drain() {
+	defer close(s.done)
+	hdr := make([]byte, 4)
+	for {
+		s.mu.Lock()
+		if s.off == s.size { s.reclaim() }
+		f, off, size, old := s.r, s.off, s.size, s.old
+		s.mu.Unlock()
+		if off == size {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.closed:
+				return
+			}
+		}
+		var ev fastqueue.Event
+		n := int64(4)
+		_, err := f.ReadAt(hdr, off)
+		if err == nil {
+			b := make([]byte, binary.BigEndian.Uint32(hdr))
+			n += int64(len(b))
+			if _, err = f.ReadAt(b, off+4); err == nil { ev, err = unmarshalDiskEvent(b) }
+		}
+		if err != nil || off+n > size {
+			// torn tail from a crash; nothing behind it is readable
+			s.mu.Lock(); s.off = size; s.mu.Unlock()
+			continue
+		}
+		skip := s.logged && off < old && ev.WALSeq != 0 // the WAL replays it
+		for !skip && !s.ring.TryPush(ev) {
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-s.closed:
+				return
+			}
+		}
+		s.mu.Lock(); s.off = off + n; s.mu.Unlock()
+	}
+}
+
+// Close stops draining; undrained events stay in the files for the next start.
+func (s *Spill) Close() error {
+	if s == nil { return nil }
+	s.once.Do(func() { close(s.closed) })
+	<-s.done
+	if s.w != s.r { _ = s.w.Close() }
+	return s.r.Close()
+}
+
+// Enqueue logs e to the shard's WAL, if any, and hands it to the ring, or to
+// the spill log when the ring is full and spill is set. While the spill holds
+// a backlog new events queue behind it, so arrival order is kept. It reports
+// false when there is no room; err is a failed WAL append.
+func (s *Shard) Enqueue(e fastqueue.Event, spill bool) (ok bool, err error) {
+	if s.WAL != nil {
+		if e.WALSeq, err = s.WAL.Append(e); err != nil { return false, err }
+	}
+	if s.Spill == nil || !spill {
+		ok = s.Ring.TryPush(e)
+	} else {
+		ok = (s.Spill.Pending() == 0 && s.Ring.TryPush(e)) || s.Spill.Push(e)
+	}
+	if !ok { s.WAL.Done(e.WALSeq) }
+	return ok, nil
+}
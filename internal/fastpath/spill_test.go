@@ -0,0 +1,97 @@
+package fastpath
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"webhook-engine/pkg/fastqueue"
+)
+
+func waitPending(t *testing.T, s *Spill, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Pending() != want {
+		if time.Now().After(deadline) { t.Fatalf("pending %d, want %d", s.Pending(), want) }
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A backlog that never empties must not run into the cap by itself.
+func TestSpillCapsPendingBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shard-00.spill")
+	ring := fastqueue.NewRing(1)
+	s, err := OpenSpill(path, SpillOptions{MaxBytes: 4096}, ring)
+	if err != nil { t.Fatal(err) }
+	defer s.Close()
+
+	ev := fastqueue.Event{Body: bytes.Repeat([]byte("x"), 100), Hdrs: [][]byte{[]byte("sig"), []byte("ts")}}
+	for i := 0; i < 1000; i++ {
+		if !s.Push(ev) { t.Fatalf("push %d refused with %d bytes pending", i, s.Pending()) }
+		ring.Pop()
+	}
+	for s.Push(ev) {}
+	if p := s.Pending(); p > 4096 { t.Fatalf("pending %d past the cap", p) }
+	for s.Pending() > 0 {
+		ring.Pop()
+	}
+	st, err := os.Stat(path)
+	if err != nil { t.Fatal(err) }
+	if st.Size() > 4096 { t.Fatalf("spill file grew to %d bytes", st.Size()) }
+}
+
+func TestSpillReplaysAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shard-00.spill")
+	ring := fastqueue.NewRing(1)
+	s, err := OpenSpill(path, SpillOptions{MaxBytes: 1 << 20}, ring)
+	if err != nil { t.Fatal(err) }
+	ring.TryPush(fastqueue.Event{}) // full, so nothing drains
+	for _, body := range []string{"a", "b", "c"} {
+		if !s.Push(fastqueue.Event{Body: []byte(body), Recv: 1}) { t.Fatal("push refused") }
+	}
+	if err := s.Close(); err != nil { t.Fatal(err) }
+	ring.Pop()
+
+	// a torn record at the tail is dropped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil { t.Fatal(err) }
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	s, err = OpenSpill(path, SpillOptions{MaxBytes: 1 << 20}, ring)
+	if err != nil { t.Fatal(err) }
+	defer s.Close()
+	var got []string
+	for len(got) < 3 {
+		got = append(got, string(ring.Pop().Body))
+	}
+	if fmt.Sprint(got) != "[a b c]" { t.Fatalf("replayed %q", got) }
+	waitPending(t, s, 0)
+}
+
+// With a WAL the spill leaves events logged before the restart to it.
+func TestSpillSkipsLoggedOnReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shard-00.spill")
+	ring := fastqueue.NewRing(1)
+	s, err := OpenSpill(path, SpillOptions{MaxBytes: 1 << 20, Logged: true}, ring)
+	if err != nil { t.Fatal(err) }
+	ring.TryPush(fastqueue.Event{})
+	s.Push(fastqueue.Event{Body: []byte("logged"), WALSeq: 7})
+	s.Push(fastqueue.Event{Body: []byte("unlogged")})
+	if err := s.Close(); err != nil { t.Fatal(err) }
+	ring.Pop()
+
+	s, err = OpenSpill(path, SpillOptions{MaxBytes: 1 << 20, Logged: true}, ring)
+	if err != nil { t.Fatal(err) }
+	defer s.Close()
+	s.Push(fastqueue.Event{Body: []byte("new"), WALSeq: 8})
+	var got []string
+	for len(got) < 2 {
+		got = append(got, string(ring.Pop().Body))
+	}
+	if fmt.Sprint(got) != "[unlogged new]" { t.Fatalf("drained %q", got) }
+	waitPending(t, s, 0)
+}
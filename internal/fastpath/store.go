@@ -0,0 +1,108 @@
+package fastpath
+
+import (
+	"errors"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// ErrNotFound is returned by Store.Get for a key that is not stored.
+var ErrNotFound = errors.New("event not found")
+
+// KV is one event under its time-ordered key.
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// Store holds a shard's events. Keys come from the shard's key generator,
+// so every AppendBatch carries keys greater than anything stored before.
+// Shard metadata (cursors, dead letters, quarantine, ...) stays in Badger
+// whatever the event store is.
+type Store interface {
+	// AppendBatch stores kvs durably, all or nothing. ttl, when positive,
+	// is a hint; stores without expiry rely on the retention sweep.
+	AppendBatch(kvs []KV, ttl time.Duration) error
+	Get(key []byte) ([]byte, error)
+	// Scan calls fn for every event with from <= key < to in key order until
+	// fn returns false; a nil to scans to the end. The slices are only valid
+	// during the call.
+	Scan(from, to []byte, fn func(key, val []byte) bool) error
+	Delete(keys ...[]byte) error
+	// Last returns the greatest stored key, or nil.
+	Last() ([]byte, error)
+	Close() error
+}
+
+// Store backends selectable per route.
+const (
+	StoreBadger   = "badger"
+	StoreSegments = "segments"
+)
+
+type StoreOptions struct {
+	Kind         string // StoreBadger (default) or StoreSegments
+	SegmentBytes int64  // segments: rotate at this size (default 64 MiB)
+	NoSync       bool   // segments: skip the fsync after each batch
+}
+
+// badgerStore keeps events under PrefixEvent in the shard's Badger DB.
+type badgerStore struct{ db *badger.DB }
+
+func (b badgerStore) AppendBatch(kvs []KV, ttl time.Duration) error {
+	// a WriteBatch is finished by Flush and cannot be reused
+	wb := b.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, kv := range kvs {
+		e := badger.NewEntry(kv.Key, kv.Value)
+		if ttl > 0 { e = e.WithTTL(ttl) }
+		if err := wb.SetEntry(e); err != nil { return err }
+	}
+	return wb.Flush()
+}
+
+func (b badgerStore) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) { return ErrNotFound }
+		if err != nil { return err }
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	return v, err
+}
+
+func (b badgerStore) Scan(from, to []byte, fn func(key, val []byte) bool) error {
+	if len(from) == 0 { from = []byte{PrefixEvent} }
+	return b.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte{PrefixEvent}
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(from); it.Valid(); it.Next() {
+			item := it.Item()
+			if len(to) > 0 && string(item.Key()) >= string(to) { return nil }
+			more := true
+			err := item.Value(func(v []byte) error { more = fn(item.Key(), v); return nil })
+			if err != nil { return err }
+			if !more { return nil }
+		}
+		return nil
+	})
+}
+
+func (b badgerStore) Delete(keys ...[]byte) error {
+	wb := b.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range keys {
+		if err := wb.Delete(k); err != nil { return err }
+	}
+	return wb.Flush()
+}
+
+func (b badgerStore) Last() ([]byte, error) { return lastKey(b.db, PrefixEvent) }
+
+// Close is a no-op; the DB belongs to the shard.
+func (b badgerStore) Close() error { return nil }
@@ -1,45 +1,61 @@
 package fastpath
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"crypto/subtle"
-	"encoding/hex"
+	"errors"
 
 	"webhook-engine/pkg/events"
 	"webhook-engine/pkg/fastqueue"
 	"webhook-engine/pkg/metrics"
+	"webhook-engine/pkg/validators"
 )
 
+var errMarshal = errors.New("envelope marshal failed")
+
 type Validator struct {
-	Token []byte
-	In    <-chan fastqueue.Event
-	Out   chan<- []byte
+	Verifier   validators.Verifier
+	In         <-chan fastqueue.Event
+	Out        chan<- Record
+	Quarantine *Quarantine // nil drops invalid events
+	DeadLetter func(DeadEntry) error
+	WAL        *WAL
+	Route      string
+	Shard      int
+	Encoding   string // events.EncodingEnvelope (default) or events.EncodingCloudEvents
 }
 
 func (v *Validator) Run() {
 	for e := range v.In {
-		if verifyV0(e.TS, e.Body, v.Token, e.Sig) {
-			metrics.ValidatedTotal.Inc()
-			val := events.Valid{ Raw: events.Raw{ Source: "zoom", Format: "json", Body: e.Body } }
-			if b := events.MarshalValid(val); b != nil {
-				v.Out <- b
+		meta, check := e.Meta, e.Check
+		if !e.Verified {
+			var err error
+			if meta, err = v.Verifier.Verify(e.Hdrs, e.Body); err != nil {
+				metrics.InvalidTotal.Inc()
+				metrics.RejectedTotal.WithLabelValues(validators.Reason(err)).Inc()
+				v.Quarantine.Put(e, validators.Reason(err))
+				if e.Done != nil { e.Done <- err }
+				v.WAL.Done(e.WALSeq)
+				continue
 			}
-		} else {
-			metrics.InvalidTotal.Inc()
+			metrics.ValidatedTotal.Inc()
+			check = "async"
+		}
+		val := events.Valid{Raw: events.Raw{Source: meta.Source, EventType: meta.EventType, Format: "json", Body: e.Body,
+			ReceivedAt: e.Recv, RemoteIP: e.Remote, Headers: e.Kept, EventTS: meta.EventTS, Verification: check, Shard: v.Shard, Traceparent: e.Trace, Route: v.Route, ID: meta.ID}}
+		b := v.marshal(val)
+		if b == nil {
+			de := DeadEntry{Stage: StageMarshal, Source: meta.Source, EventType: meta.EventType, Recv: e.Recv, Value: e.Body, Err: errMarshal.Error(), Attempts: 1}
+			if v.DeadLetter == nil || v.DeadLetter(de) != nil { metrics.LostTotal.WithLabelValues(StageMarshal).Inc() }
+			if e.Done != nil { e.Done <- errMarshal }
+			v.WAL.Done(e.WALSeq)
+			continue
 		}
+		v.Out <- Record{Recv: e.Recv, Value: b, Body: e.Body, ID: meta.ID, Done: e.Done, WALSeq: e.WALSeq}
 	}
 }
 
-func verifyV0(ts, body, token, sig []byte) bool {
-	msg := make([]byte, 0, 3+len(ts)+1+len(body))
-	msg = append(msg, 'v','0',':')
-	msg = append(msg, ts...)
-	msg = append(msg, ':')
-	msg = append(msg, body...)
-	h := hmac.New(sha256.New, token); h.Write(msg)
-	sum := h.Sum(nil)
-	hexBuf := make([]byte, hex.EncodedLen(len(sum))); hex.Encode(hexBuf, sum)
-	want := append([]byte("v0="), hexBuf...)
-	return subtle.ConstantTimeCompare(want, sig) == 1
+func (v *Validator) marshal(val events.Valid) []byte {
+	if v.Encoding != events.EncodingCloudEvents { return events.MarshalValid(val) }
+	b, err := events.MarshalCloudEvent(val)
+	if err != nil { return nil }
+	return b
 }
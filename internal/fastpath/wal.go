@@ -0,0 +1,263 @@
+package fastpath
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"webhook-engine/pkg/fastqueue"
+)
+
+type WALOptions struct {
+	Enabled      bool
+	SegmentBytes int64 // rotate once a segment reaches this size
+	Fsync        bool  // sync every append; without it a WAL survives process crashes only
+}
+
+const DefaultWALSegment = 64 << 20
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WAL is a shard's write-ahead log of accepted events. The handler appends
+// an event before acknowledging it; once the event is persisted, quarantined
+// or dead-lettered its sequence is settled. Every sequence up to the
+// watermark is settled, and the watermark is stored under PrefixWAL in the
+// shard DB after each flush, so on start everything behind it is replayed.
+//
+// Entries are uint32 length | uint32 CRC-32C | uint64 sequence | event, the
+// length and CRC covering sequence and event. Segments are named after their
+// first sequence and removed once the watermark passes them.
+type WAL struct {
+	dir    string
+	o      WALOptions
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	segs   []uint64 // first sequence of every segment, oldest first
+	next   uint64
+	pend   []uint64 // appended and not yet settled, in order
+	done   map[uint64]bool
+	mark   uint64 // every sequence <= mark is settled
+	stored uint64 // mark as last written to the DB
+
+	replayFrom uint64
+	replaySegs []uint64
+	quit       chan struct{}
+	replaying  sync.WaitGroup
+	once       sync.Once
+}
+
+func walSegment(first uint64) string { return fmt.Sprintf("%020d.wal", first) }
+
+// walMark reads the stored watermark.
+func walMark(db *badger.DB) (uint64, error) {
+	var mark uint64
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte{PrefixWAL})
+		if errors.Is(err, badger.ErrKeyNotFound) { return nil }
+		if err != nil { return err }
+		return item.Value(func(v []byte) error {
+			if len(v) == 8 { mark = binary.BigEndian.Uint64(v) }
+			return nil
+		})
+	})
+	return mark, err
+}
+
+// OpenWAL opens the log in dir, whose entries up to committed are settled.
+// It checks the existing segments, cuts off anything behind a torn or
+// corrupt entry and starts a fresh segment for new appends.
+func OpenWAL(dir string, o WALOptions, committed uint64) (*WAL, error) {
+	if o.SegmentBytes <= 0 { o.SegmentBytes = DefaultWALSegment }
+	if err := os.MkdirAll(dir, 0o700); err != nil { return nil, err }
+	names, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if err != nil { return nil, err }
+	w := &WAL{dir: dir, o: o, next: committed + 1, mark: committed, stored: committed, replayFrom: committed,
+		done: map[uint64]bool{}, quit: make(chan struct{})}
+	for _, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), ".wal"), 10, 64)
+		if err != nil { continue }
+		w.segs = append(w.segs, first)
+	}
+	sort.Slice(w.segs, func(i, j int) bool { return w.segs[i] < w.segs[j] })
+	for _, first := range w.segs {
+		err := w.read(first, true, func(seq uint64, _ []byte) bool {
+			if seq >= w.next { w.next = seq + 1 }
+			if seq > committed { w.pend = append(w.pend, seq) }
+			return true
+		})
+		if err != nil { return nil, err }
+	}
+	w.replaySegs = append([]uint64(nil), w.segs...)
+	if err := w.rotate(); err != nil { return nil, err }
+	w.settle()
+	return w, nil
+}
+
+// read calls fn for every intact entry of segment first. With repair set a
+// torn or corrupt tail is truncated away.
+func (w *WAL) read(first uint64, repair bool, fn func(seq uint64, ev []byte) bool) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, walSegment(first)), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) { return nil } // settled and removed meanwhile
+	if err != nil { return err }
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil { return err }
+	var off int64
+	hdr := make([]byte, 8)
+	for {
+		_, err := f.ReadAt(hdr, off)
+		var b []byte
+		if err == nil && int64(binary.BigEndian.Uint32(hdr)) > st.Size()-off-8 { err = io.ErrUnexpectedEOF }
+		if err == nil {
+			b = make([]byte, binary.BigEndian.Uint32(hdr))
+			_, err = f.ReadAt(b, off+8)
+			if err == nil && (len(b) < 8 || crc32.Checksum(b, walTable) != binary.BigEndian.Uint32(hdr[4:])) { err = io.ErrUnexpectedEOF }
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			// clean end, torn write or corruption: nothing behind it is trusted
+			if repair { return f.Truncate(off) }
+			return nil
+		}
+		if err != nil { return err }
+		if !fn(binary.BigEndian.Uint64(b), b[8:]) { return nil }
+		off += 8 + int64(len(b))
+	}
+}
+
+// rotate starts a new segment at w.next. w.mu must be held or w unshared.
+func (w *WAL) rotate() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil { return err }
+	}
+	f, err := os.OpenFile(filepath.Join(w.dir, walSegment(w.next)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil { return err }
+	if n := len(w.segs); n == 0 || w.segs[n-1] != w.next { w.segs = append(w.segs, w.next) }
+	w.f, w.size = f, 0
+	return nil
+}
+
+// Append logs e and returns its sequence.
+func (w *WAL) Append(e fastqueue.Event) (uint64, error) {
+	b, err := marshalDiskEvent(e)
+	if err != nil { return 0, err }
+	rec := make([]byte, 16+len(b))
+	binary.BigEndian.PutUint32(rec, uint32(8+len(b)))
+	copy(rec[16:], b)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size > 0 && w.size+int64(len(rec)) > w.o.SegmentBytes {
+		if err := w.rotate(); err != nil { return 0, err }
+	}
+	seq := w.next
+	binary.BigEndian.PutUint64(rec[8:], seq)
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(rec[8:], walTable))
+	_, err = w.f.Write(rec)
+	if err == nil && w.o.Fsync { err = w.f.Sync() }
+	// a torn record would end replay early and hide the ones after it
+	if err != nil { _ = w.f.Truncate(w.size); return 0, err }
+	w.size += int64(len(rec))
+	w.next++
+	w.pend = append(w.pend, seq)
+	return seq, nil
+}
+
+// Done settles seq. It is a no-op for seq 0, a settled seq or a nil WAL.
+func (w *WAL) Done(seq uint64) {
+	if w == nil || seq == 0 { return }
+	w.mu.Lock()
+	if seq <= w.mark { w.mu.Unlock(); return }
+	w.done[seq] = true
+	w.settle()
+	w.mu.Unlock()
+}
+
+// settle advances the watermark past the settled head of pend.
+func (w *WAL) settle() {
+	for len(w.pend) > 0 && w.done[w.pend[0]] {
+		delete(w.done, w.pend[0])
+		w.pend = w.pend[1:]
+	}
+	if len(w.pend) > 0 {
+		w.mark = w.pend[0] - 1
+	} else {
+		w.mark = w.next - 1
+	}
+}
+
+// Checkpoint stores the watermark in db and removes segments behind it.
+func (w *WAL) Checkpoint(db *badger.DB) error {
+	if w == nil { return nil }
+	w.mu.Lock()
+	mark, stored := w.mark, w.stored
+	w.mu.Unlock()
+	if mark == stored { return nil }
+	v := binary.BigEndian.AppendUint64(nil, mark)
+	if err := db.Update(func(txn *badger.Txn) error { return txn.Set([]byte{PrefixWAL}, v) }); err != nil { return err }
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stored = mark
+	// a segment is done once the next one starts at or below mark+1
+	for len(w.segs) > 1 && w.segs[1] <= mark+1 {
+		if err := os.Remove(filepath.Join(w.dir, walSegment(w.segs[0]))); err != nil && !errors.Is(err, os.ErrNotExist) { return err }
+		w.segs = w.segs[1:]
+	}
+	return nil
+}
+
+// Replay starts feeding the entries that were unsettled at open back into
+// ring. Events the handler had verified keep their flag; verify, when set,
+// gets a chance at the others before the validators do (replayed timestamps
+// may be stale by now).
+func (w *WAL) Replay(ring *fastqueue.Ring, verify func(*fastqueue.Event)) {
+	w.replaying.Add(1)
+	go func() { defer w.replaying.Done(); w.replay(ring, verify) }()
+}
+
+func (w *WAL) replay(ring *fastqueue.Ring, verify func(*fastqueue.Event)) {
+	for _, first := range w.replaySegs {
+		err := w.read(first, false, func(seq uint64, b []byte) bool {
+			if seq <= w.replayFrom { return true }
+			ev, err := unmarshalDiskEvent(b)
+			if err != nil { w.Done(seq); return true }
+			ev.WALSeq = seq
+			if !ev.Verified && verify != nil { verify(&ev) }
+			for !ring.TryPush(ev) {
+				select {
+				case <-time.After(5 * time.Millisecond):
+				case <-w.quit:
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil { return }
+		select {
+		case <-w.quit:
+			return
+		default:
+		}
+	}
+}
+
+// Close stops a running replay and closes the current segment. Unsettled
+// entries are replayed on the next open.
+func (w *WAL) Close() error {
+	if w == nil { return nil }
+	w.once.Do(func() { close(w.quit) })
+	w.replaying.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
@@ -1,70 +1,193 @@
 package fastpath
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
 
 	"webhook-engine/pkg/fastqueue"
 	"webhook-engine/pkg/metrics"
+	"webhook-engine/pkg/validators"
 )
 
 type Shard struct {
 	Ring       *fastqueue.Ring
-	ValOut     chan []byte
-	DB         *badger.DB
+	ValOut     chan Record
+	DB         *badger.DB // shard metadata, and the events unless Store says otherwise
+	Store      Store
+	codec      *codecStore // wraps the backend, so also Store
+	usage      *eventBytes // event keyspace size; nil without a byte limit
+	Quarantine *Quarantine
+	Consumers  *Consumers
+	Spill      *Spill // nil unless overflow spilling is enabled
+	WAL        *WAL   // nil unless the write-ahead log is enabled
+	flushed    atomic.Pointer[[]byte]
+	persisted  atomic.Int64 // events written since open
+	validators sync.WaitGroup
+	bg         sync.WaitGroup // maintenance loops, stopped through quit
+	quit       chan struct{}
+	gc         gcState
+	flatten    bool
+	deadMu     sync.Mutex
+	deadKeys   keyGen
+	deadDepth  atomic.Int64
+	health     health
 	done       chan struct{}
 }
 
-func OpenBadger(dir string) (*badger.DB, error) {
+// Options configures one shard set.
+type Options struct {
+	Route         string // metrics label
+	Shards        int
+	BaseDir       string
+	RingSize      int
+	ValidatorsPer int
+	BatchSize     int
+	Linger        time.Duration
+	Verifier      validators.Verifier
+	Quarantine    QuarantineOptions
+	Spill         SpillOptions
+	WAL           WALOptions
+	Store         StoreOptions
+	Encryption    EncryptionOptions
+	Compression   CompressionOptions
+	Encoding      string // stored form, see events.EncodingEnvelope
+	Dedupe        time.Duration // idempotency window; 0 disables deduplication
+	Retention     RetentionOptions
+	GC            GCOptions
+	Visibility    time.Duration // consumer redelivery timeout
+}
+
+// OpenBadger opens the shard DB in dir, encrypted when enc has a key and with
+// comp's block compression.
+func OpenBadger(dir string, enc EncryptionOptions, comp CompressionOptions) (*badger.DB, error) {
 	opts := badger.DefaultOptions(dir).
 		WithSyncWrites(true).
 		WithTruncate(true).
-		WithCompression(badger.None).
 		WithValueLogFileSize(64<<20).
 		WithBlockCacheSize(16<<20).
 		WithIndexCacheSize(16<<20)
-	return badger.Open(opts)
+	db, err := badger.Open(comp.apply(enc.apply(opts)))
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) { err = fmt.Errorf("%s: %w (see the rekey command)", dir, err) }
+	return db, err
 }
 
-func BuildShards(n int, baseDir string, ringSize, validatorsPer, batchSize int, linger time.Duration, token []byte) ([]*Shard, func() error, error) {
+// BuildShards opens and starts o.Shards shards. The returned stop func
+// drains them; see DrainStats.
+func BuildShards(o Options) ([]*Shard, func(context.Context) (DrainStats, error), error) {
+	n := o.Shards
 	if n <= 0 { n = 1 }
-	out := make([]*Shard, n)
+	out := make([]*Shard, 0, n)
+	stop := func(ctx context.Context) (DrainStats, error) { return drainAll(ctx, out) }
+	abort := func(err error) ([]*Shard, func(context.Context) (DrainStats, error), error) {
+		_, _ = stop(context.Background())
+		return nil, nil, err
+	}
 	for i := 0; i < n; i++ {
-		db, err := OpenBadger(filepath.Join(baseDir, fmt.Sprintf("shard-%02d", i)))
-		if err != nil { return nil, nil, err }
-		r := fastqueue.NewRing(ringSize)
-		valOut := make(chan []byte, ringSize)
-
-		for v := 0; v < validatorsPer; v++ {
-			go (&Validator{Token: token, In: r.C(), Out: valOut}).Run()
+		db, err := OpenBadger(filepath.Join(o.BaseDir, fmt.Sprintf("shard-%02d", i)), o.Encryption, o.Compression)
+		if err != nil { return abort(err) }
+		var q *Quarantine
+		if o.Quarantine.Enabled {
+			if q, err = NewQuarantine(db, o.Quarantine); err != nil { _ = db.Close(); return abort(err) }
 		}
+		r := fastqueue.NewRing(o.RingSize)
+		valOut := make(chan Record, o.RingSize)
 
-		bw := &BatchWriter{DB: db, In: valOut, MaxN: batchSize, Linger: linger}
-		done := make(chan struct{})
-		go func(){ bw.Run(); close(done) }()
+		s := &Shard{Ring: r, ValOut: valOut, DB: db, Quarantine: q, done: make(chan struct{}), quit: make(chan struct{}), flatten: o.GC.Flatten}
+		s.Consumers = NewConsumers(s, o.Visibility)
+		cleanup := func(err error) ([]*Shard, func(context.Context) (DrainStats, error), error) {
+			_ = s.WAL.Close(); _ = s.Spill.Close(); q.Close()
+			if s.Store != nil { _ = s.Store.Close() }
+			_ = db.Close()
+			return abort(err)
+		}
+		switch o.Store.Kind {
+		case StoreSegments:
+			seg, err := OpenSegmentStore(filepath.Join(o.BaseDir, fmt.Sprintf("shard-%02d.seg", i)), o.Store)
+			if err != nil { return cleanup(err) }
+			s.Store = seg
+		default:
+			s.Store = badgerStore{db}
+		}
+		if s.codec, err = newCodecStore(s.Store, db, o.Compression); err != nil { return cleanup(err) }
+		s.Store = s.codec
+		if o.Retention.MaxBytes > 0 {
+			if s.usage, err = countEvents(s.Store); err != nil { return cleanup(err) }
+			s.Store = sizedStore{s.Store, s.usage}
+		}
+		if o.Spill.Enabled {
+			o.Spill.Logged = o.WAL.Enabled
+			if s.Spill, err = OpenSpill(filepath.Join(o.BaseDir, fmt.Sprintf("shard-%02d.spill", i)), o.Spill, r); err != nil { return cleanup(err) }
+		}
+		if o.WAL.Enabled {
+			mark, err := walMark(db)
+			if err != nil { return cleanup(err) }
+			if s.WAL, err = OpenWAL(filepath.Join(o.BaseDir, fmt.Sprintf("shard-%02d.wal", i)), o.WAL, mark); err != nil { return cleanup(err) }
+		}
+		if err := s.openDeadLetters(); err != nil { return cleanup(err) }
 
-		out[i] = &Shard{Ring: r, ValOut: valOut, DB: db, done: done}
-	}
-	stop := func() error {
-		var first error
-		for _, s := range out {
-			close(s.ValOut)
-			<-s.done
-			if err := s.DB.Close(); err != nil && first == nil { first = err }
+		for v := 0; v < o.ValidatorsPer; v++ {
+			s.validators.Add(1)
+			go func() {
+				defer s.validators.Done()
+				(&Validator{Verifier: o.Verifier, In: r.C(), Out: valOut, Quarantine: q, DeadLetter: s.DeadLetter, WAL: s.WAL,
+					Route: o.Route, Shard: i, Encoding: o.Encoding}).Run()
+			}()
 		}
-		return first
+
+		werrs := metrics.WriteErrors.WithLabelValues(o.Route, fmt.Sprintf("%d", i))
+		dups := metrics.DuplicatesTotal.WithLabelValues(o.Route)
+		bw := &BatchWriter{DB: db, Store: s.Store, In: valOut, MaxN: o.BatchSize, Linger: o.Linger, Flushed: &s.flushed, Persisted: &s.persisted, DeadLetter: s.DeadLetter,
+			WAL: s.WAL, TTL: o.Retention.MaxAge, Dedupe: o.Dedupe, OnDuplicate: dups.Inc, OnError: func(error) { werrs.Inc() }, health: &s.health}
+		go func(){ bw.Run(); close(s.done) }()
+		if s.WAL != nil { s.WAL.Replay(r, replayVerify(o.Verifier)) }
+		s.bg.Add(2)
+		go s.sweep(o.Retention, o.Route)
+		go s.collect(o.GC, o.Route, fmt.Sprintf("%d", i))
+
+		out = append(out, s)
 	}
 	return out, stop, nil
 }
 
-func ReportShardMetrics(shards []*Shard) {
+// replayVerify checks WAL-replayed events by signature only when v can,
+// since their timestamps may be past the skew window and the replay cache
+// does not know them after a restart.
+func replayVerify(v validators.Verifier) func(*fastqueue.Event) {
+	sv, ok := v.(validators.SignatureVerifier)
+	if !ok { return nil }
+	return func(e *fastqueue.Event) {
+		meta, err := sv.VerifySignature(e.Hdrs, e.Body)
+		if err != nil { return }
+		metrics.ValidatedTotal.Inc()
+		e.Verified, e.Check, e.Meta = true, "signature", meta
+	}
+}
+
+func ReportShardMetrics(route string, shards []*Shard) {
 	total := 0
 	for i, s := range shards {
 		q := s.Ring.Len()
-		metrics.FastShardQueued.WithLabelValues(fmt.Sprintf("%d", i)).Set(float64(q))
+		metrics.FastShardQueued.WithLabelValues(route, fmt.Sprintf("%d", i)).Set(float64(q))
+		metrics.DLQDepth.WithLabelValues(route, fmt.Sprintf("%d", i)).Set(float64(s.DeadDepth()))
+		if s.Spill != nil { metrics.SpillBytes.WithLabelValues(route, fmt.Sprintf("%d", i)).Set(float64(s.Spill.Pending())) }
+		healthy := 0.0
+		if s.Healthy() { healthy = 1 }
+		lsm, vlog := s.DiskSize()
+		metrics.DiskBytes.WithLabelValues(route, fmt.Sprintf("%d", i), "lsm").Set(float64(lsm))
+		metrics.DiskBytes.WithLabelValues(route, fmt.Sprintf("%d", i), "vlog").Set(float64(vlog))
+		if seg, ok := s.codec.Store.(interface{ Size() int64 }); ok {
+			metrics.DiskBytes.WithLabelValues(route, fmt.Sprintf("%d", i), "segments").Set(float64(seg.Size()))
+		}
+		metrics.CompressionRatio.WithLabelValues(route, fmt.Sprintf("%d", i), "block").Set(s.BlockRatio())
+		metrics.CompressionRatio.WithLabelValues(route, fmt.Sprintf("%d", i), "value").Set(s.codec.ValueRatio())
+		metrics.ShardHealthy.WithLabelValues(route, fmt.Sprintf("%d", i)).Set(healthy)
 		total += q
 	}
 	_ = total
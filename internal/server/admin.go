@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/validators"
+)
+
+var adminPrefix = []byte("/admin/")
+
+// admin serves the operator endpoints under /admin/. Every request must
+// carry admin.token as a bearer token; without a token configured the
+// endpoints do not exist.
+func (a *App) admin(ctx *fasthttp.RequestCtx) {
+	if a.Cfg.Admin.Token == "" { ctx.SetStatusCode(404); return }
+	want := []byte("Bearer " + a.Cfg.Admin.Token)
+	if subtle.ConstantTimeCompare(ctx.Request.Header.Peek("Authorization"), want) != 1 {
+		writeJSON(ctx, map[string]string{"error": "unauthorized"}, 401); return
+	}
+	switch string(ctx.Path()) {
+	case "/admin/consumers":
+		a.consumerList(ctx)
+	case "/admin/consumers/fetch":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.consumerFetch(ctx)
+	case "/admin/consumers/ack":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.consumerAck(ctx)
+	case "/admin/dlq":
+		a.dlqList(ctx)
+	case "/admin/dlq/requeue":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.dlqRequeue(ctx)
+	case "/admin/dlq/purge":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.dlqPurge(ctx)
+	case "/admin/gc":
+		a.gcList(ctx)
+	case "/admin/gc/pause", "/admin/gc/resume":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.gcPause(ctx, string(ctx.Path()) == "/admin/gc/pause")
+	case "/admin/events":
+		a.eventList(ctx)
+	case "/admin/events/get":
+		a.eventGet(ctx)
+	case "/admin/quarantine":
+		a.quarantineList(ctx)
+	case "/admin/quarantine/replay":
+		if !ctx.IsPost() { ctx.SetStatusCode(405); return }
+		a.quarantineReplay(ctx)
+	default:
+		ctx.SetStatusCode(404)
+	}
+}
+
+// shardArgs resolves the route= and optional shard= query arguments. Without
+// shard= every shard of the route is selected.
+func (a *App) shardArgs(ctx *fasthttp.RequestCtx) (*Route, []int, error) {
+	name := string(ctx.QueryArgs().Peek("route"))
+	var rt *Route
+	for _, r := range a.Routes {
+		if r.Name == name { rt = r }
+	}
+	if rt == nil { return nil, nil, fmt.Errorf("unknown route %q", name) }
+	if !ctx.QueryArgs().Has("shard") {
+		all := make([]int, len(rt.Shards))
+		for i := range all {
+			all[i] = i
+		}
+		return rt, all, nil
+	}
+	i, err := strconv.Atoi(string(ctx.QueryArgs().Peek("shard")))
+	if err != nil || i < 0 || i >= len(rt.Shards) { return nil, nil, fmt.Errorf("bad shard %q", ctx.QueryArgs().Peek("shard")) }
+	return rt, []int{i}, nil
+}
+
+func queryInt(ctx *fasthttp.RequestCtx, name string, def int) int {
+	if n, err := strconv.Atoi(string(ctx.QueryArgs().Peek(name))); err == nil && n > 0 { return n }
+	return def
+}
+
+type quarantineView struct {
+	Shard int    `json:"shard"`
+	Key   string `json:"key"`
+	fastpath.QuarantineEntry
+}
+
+// GET /admin/quarantine?route=R[&shard=N][&after=KEY][&limit=N]
+func (a *App) quarantineList(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	after, err := hex.DecodeString(string(ctx.QueryArgs().Peek("after")))
+	if err != nil || (len(after) > 0 && len(shards) != 1) {
+		writeJSON(ctx, map[string]string{"error": "after needs a hex key and a single shard"}, 400); return
+	}
+	limit := queryInt(ctx, "limit", 100)
+	out := []quarantineView{}
+	for _, i := range shards {
+		q := rt.Shards[i].Quarantine
+		if q == nil { continue }
+		entries, err := q.List(after, limit-len(out))
+		if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+		for _, e := range entries {
+			out = append(out, quarantineView{Shard: i, Key: hex.EncodeToString(e.Key), QuarantineEntry: e})
+		}
+		if len(out) >= limit { break }
+	}
+	writeJSON(ctx, out, 200)
+}
+
+// POST /admin/quarantine/replay?route=R[&shard=N][&key=KEY]
+//
+// Re-verifies quarantined events against the route's current secrets and
+// feeds the ones that pass back into their shard. An event leaves quarantine
+// only once its shard has persisted it. Freshness and replay checks are
+// skipped when the verifier supports it, since quarantined timestamps are
+// stale by definition.
+func (a *App) quarantineReplay(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	key, err := hex.DecodeString(string(ctx.QueryArgs().Peek("key")))
+	if err != nil { writeJSON(ctx, map[string]string{"error": "bad key"}, 400); return }
+	verify, check := rt.Verifier.Verify, "sync"
+	if sv, ok := rt.Verifier.(validators.SignatureVerifier); ok { verify, check = sv.VerifySignature, "signature" }
+
+	var res struct{ Replayed, Failed, Busy int }
+	for _, i := range shards {
+		q := rt.Shards[i].Quarantine
+		if q == nil { continue }
+		var after []byte
+		for {
+			entries, err := q.List(after, 256)
+			if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+			if len(entries) == 0 { break }
+			after = entries[len(entries)-1].Key
+			for _, e := range entries {
+				if len(key) > 0 && string(e.Key) != string(key) { continue }
+				ev := e.Event()
+				meta, err := verify(ev.Hdrs, ev.Body)
+				if err != nil { res.Failed++; continue }
+				ev.Verified, ev.Check, ev.Meta = true, check, meta
+				busy, err := enqueueDurable(rt.Shards[fastqueue.ShardFor(ev.Body, len(rt.Shards))], ev)
+				if busy { res.Busy++; continue }
+				if err != nil { res.Failed++; continue }
+				if err := q.Delete(e.Key); err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+				res.Replayed++
+			}
+		}
+	}
+	writeJSON(ctx, map[string]int{"replayed": res.Replayed, "failed": res.Failed, "busy": res.Busy}, 200)
+}
+
+// requeueTimeout bounds how long an admin requeue waits for one event to be
+// persisted before leaving its source entry in place.
+const requeueTimeout = 10 * time.Second
+
+// enqueueDurable feeds ev into shard s and waits until the batch writer has
+// stored it, so the caller may drop its own copy. busy reports a full shard;
+// err a WAL, verification or storage failure, or a timeout.
+func enqueueDurable(s *fastpath.Shard, ev fastqueue.Event) (busy bool, err error) {
+	done := make(chan error, 1)
+	ev.Done = done
+	ok, err := s.Enqueue(ev, false)
+	if err != nil || !ok { return err == nil, err }
+	timer := time.NewTimer(requeueTimeout); defer timer.Stop()
+	select {
+	case err = <-done:
+		return false, err
+	case <-timer.C:
+		return false, errors.New("persist timeout")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"webhook-engine/internal/delivery"
+	"webhook-engine/internal/fastpath"
+)
+
+// groupArg returns the group= argument. Names under the forwarders' prefix
+// are refused so API consumers cannot move a destination's offset.
+func groupArg(ctx *fasthttp.RequestCtx) (string, error) {
+	group := string(ctx.QueryArgs().Peek("group"))
+	if strings.HasPrefix(group, delivery.Group("")) { return "", fmt.Errorf("group prefix %q is reserved for forwarders", delivery.Group("")) }
+	return group, nil
+}
+
+// POST /admin/consumers/fetch?group=G&route=R[&shard=N][&max=N]
+//
+// Hands out the next batch of events to consumer group G. Events stay
+// outstanding until acknowledged and are redelivered after the route's
+// visibility timeout.
+func (a *App) consumerFetch(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	group, err := groupArg(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	max := queryInt(ctx, "max", 100)
+	out := []eventView{}
+	for _, i := range shards {
+		msgs, err := rt.Shards[i].Consumers.Fetch(group, max-len(out))
+		if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+		for _, m := range msgs {
+			v, err := newEventView(i, m.Key, m.Value)
+			if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+			out = append(out, v)
+		}
+		if len(out) >= max { break }
+	}
+	writeJSON(ctx, out, 200)
+}
+
+// POST /admin/consumers/ack?group=G&route=R
+// body: [{"shard":N,"key":"KEY"}, ...]
+//
+// Returns the committed offset of every shard touched.
+func (a *App) consumerAck(ctx *fasthttp.RequestCtx) {
+	rt, _, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	group, err := groupArg(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	var acks []struct {
+		Shard int    `json:"shard"`
+		Key   string `json:"key"`
+	}
+	if err := json.Unmarshal(ctx.PostBody(), &acks); err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	byShard := map[int][][]byte{}
+	for _, ack := range acks {
+		k, err := hex.DecodeString(ack.Key)
+		if err != nil || ack.Shard < 0 || ack.Shard >= len(rt.Shards) {
+			writeJSON(ctx, map[string]string{"error": "bad ack " + ack.Key}, 400); return
+		}
+		byShard[ack.Shard] = append(byShard[ack.Shard], k)
+	}
+	committed := map[int]string{}
+	for i, keys := range byShard {
+		c, err := rt.Shards[i].Consumers.Ack(group, keys)
+		if errors.Is(err, fastpath.ErrGroupName) { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+		if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+		committed[i] = hex.EncodeToString(c)
+	}
+	writeJSON(ctx, map[string]any{"committed": committed}, 200)
+}
+
+// GET /admin/consumers?route=R[&shard=N]
+func (a *App) consumerList(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	out := map[int]map[string]string{}
+	for _, i := range shards {
+		groups, err := rt.Shards[i].Consumers.Groups()
+		if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+		out[i] = map[string]string{}
+		for g, k := range groups {
+			out[i][g] = hex.EncodeToString(k)
+		}
+	}
+	writeJSON(ctx, out, 200)
+}
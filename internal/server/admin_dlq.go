@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/hex"
+
+	"github.com/valyala/fasthttp"
+
+	"webhook-engine/internal/delivery"
+	"webhook-engine/internal/fastpath"
+)
+
+type deadView struct {
+	Shard int    `json:"shard"`
+	Key   string `json:"key"`
+	fastpath.DeadEntry
+}
+
+func deadFilter(ctx *fasthttp.RequestCtx) fastpath.DeadFilter {
+	return fastpath.DeadFilter{Stage: string(ctx.QueryArgs().Peek("stage")), Dest: string(ctx.QueryArgs().Peek("dest"))}
+}
+
+// eachDead calls fn for every dead-letter entry of the selected shards that
+// matches the stage=/dest= filter, or only for key= when given.
+func (a *App) eachDead(ctx *fasthttp.RequestCtx, rt *Route, shards []int, fn func(i int, e fastpath.DeadEntry) error) error {
+	key, err := hex.DecodeString(string(ctx.QueryArgs().Peek("key")))
+	if err != nil { return err }
+	f := deadFilter(ctx)
+	for _, i := range shards {
+		var after []byte
+		for {
+			entries, err := rt.Shards[i].DeadLetters(f, after, 256)
+			if err != nil { return err }
+			if len(entries) == 0 { break }
+			after = entries[len(entries)-1].Key
+			for _, e := range entries {
+				if len(key) > 0 && string(e.Key) != string(key) { continue }
+				if err := fn(i, e); err != nil { return err }
+			}
+		}
+	}
+	return nil
+}
+
+// GET /admin/dlq?route=R[&shard=N][&stage=S][&dest=D][&after=KEY][&limit=N]
+func (a *App) dlqList(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	after, err := hex.DecodeString(string(ctx.QueryArgs().Peek("after")))
+	if err != nil || (len(after) > 0 && len(shards) != 1) {
+		writeJSON(ctx, map[string]string{"error": "after needs a hex key and a single shard"}, 400); return
+	}
+	limit := queryInt(ctx, "limit", 100)
+	out := []deadView{}
+	for _, i := range shards {
+		entries, err := rt.Shards[i].DeadLetters(deadFilter(ctx), after, limit-len(out))
+		if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+		for _, e := range entries {
+			out = append(out, deadView{Shard: i, Key: hex.EncodeToString(e.Key), DeadEntry: e})
+		}
+		if len(out) >= limit { break }
+	}
+	writeJSON(ctx, out, 200)
+}
+
+// POST /admin/dlq/requeue?route=R[&shard=N][&key=KEY][&stage=S][&dest=D]
+//
+// Sends entries back to the step that failed: persist and marshal entries
+// through their shard again, deliver entries to their destination's
+// forwarder. An entry leaves the DLQ once its event is persisted again or
+// handed to the forwarder; entries that cannot be requeued right now (full
+// shard, unknown destination) are skipped, and ones whose second attempt
+// fails are counted as failed. Both stay.
+func (a *App) dlqRequeue(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	dests := map[string]bool{}
+	for _, f := range rt.Forwards {
+		dests[f.Dest.Name] = true
+	}
+	var requeued, skipped, failed int
+	err = a.eachDead(ctx, rt, shards, func(i int, e fastpath.DeadEntry) error {
+		s := rt.Shards[i]
+		switch e.Stage {
+		case fastpath.StagePersist, fastpath.StageMarshal:
+			ev, err := e.Event()
+			if err != nil { failed++; return nil }
+			busy, err := enqueueDurable(s, ev)
+			if busy { skipped++; return nil }
+			if err != nil { failed++; return nil }
+		case fastpath.StageDeliver:
+			if !dests[e.Dest] { skipped++; return nil }
+			if err := s.Redeliver(delivery.Group(e.Dest), e.EventKey, e.Value); err != nil { return err }
+		default:
+			skipped++
+			return nil
+		}
+		requeued++
+		return s.DeleteDead(e.Key)
+	})
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+	writeJSON(ctx, map[string]int{"requeued": requeued, "skipped": skipped, "failed": failed}, 200)
+}
+
+// POST /admin/dlq/purge?route=R[&shard=N][&key=KEY][&stage=S][&dest=D]
+func (a *App) dlqPurge(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	purged := 0
+	err = a.eachDead(ctx, rt, shards, func(i int, e fastpath.DeadEntry) error {
+		purged++
+		return rt.Shards[i].DeleteDead(e.Key)
+	})
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+	writeJSON(ctx, map[string]int{"purged": purged}, 200)
+}
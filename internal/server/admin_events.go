@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/pkg/events"
+)
+
+type eventView struct {
+	Shard      int          `json:"shard"`
+	Key        string       `json:"key"`
+	ReceivedAt int64        `json:"received_at"`
+	Event      events.Valid `json:"event"`
+}
+
+func newEventView(shard int, key, val []byte) (eventView, error) {
+	ev, err := events.UnmarshalValid(val)
+	if err != nil { return eventView{}, err }
+	ns, _, _ := fastpath.ParseKey(key)
+	return eventView{Shard: shard, Key: hex.EncodeToString(key), ReceivedAt: ns, Event: ev}, nil
+}
+
+// GET /admin/events/get?route=R&shard=N&key=KEY
+func (a *App) eventGet(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err == nil && len(shards) != 1 { err = errors.New("shard is required") }
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	key, err := hex.DecodeString(string(ctx.QueryArgs().Peek("key")))
+	if err != nil || len(key) == 0 { writeJSON(ctx, map[string]string{"error": "bad key"}, 400); return }
+	val, err := rt.Shards[shards[0]].Get(key)
+	if errors.Is(err, fastpath.ErrNotFound) { writeJSON(ctx, map[string]string{"error": "not found"}, 404); return }
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+	v, err := newEventView(shards[0], key, val)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 500); return }
+	writeJSON(ctx, v, 200)
+}
+
+// GET /admin/events?route=R[&shard=N][&from=T][&to=T][&after=KEY]
+//                   [&source=S][&type=E][&limit=N][&format=json|ndjson]
+//
+// Streams stored events in arrival order. from/to are RFC 3339 receive-time
+// bounds (to is exclusive); after resumes behind a key from a previous page
+// and needs a single shard. The response is written as it is scanned, so
+// large ranges are never buffered.
+func (a *App) eventList(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	args := ctx.QueryArgs()
+	var from, to []byte
+	for _, b := range []struct {
+		name string
+		dst  *[]byte
+	}{{"from", &from}, {"to", &to}} {
+		if !args.Has(b.name) { continue }
+		t, err := time.Parse(time.RFC3339Nano, string(args.Peek(b.name)))
+		if err != nil { writeJSON(ctx, map[string]string{"error": b.name + ": " + err.Error()}, 400); return }
+		*b.dst = fastpath.EventKey(t.UnixNano(), 0)
+	}
+	after, err := hex.DecodeString(string(args.Peek("after")))
+	if err != nil || (len(after) > 0 && len(shards) != 1) {
+		writeJSON(ctx, map[string]string{"error": "after needs a hex key and a single shard"}, 400); return
+	}
+	if len(after) > 0 && string(after) >= string(from) { from = append(after, 0) }
+	source, typ := string(args.Peek("source")), string(args.Peek("type"))
+	limit := queryInt(ctx, "limit", 1000)
+	ndjson := string(args.Peek("format")) == "ndjson"
+
+	if ndjson {
+		ctx.Response.Header.SetContentType("application/x-ndjson")
+	} else {
+		ctx.Response.Header.SetContentType("application/json")
+	}
+	ctx.SetStatusCode(200)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		enc := json.NewEncoder(w)
+		n := 0
+		if !ndjson { _, _ = w.WriteString("[") }
+		for _, i := range shards {
+			err := rt.Shards[i].Scan(from, to, func(key, val []byte) bool {
+				v, err := newEventView(i, key, val)
+				if err != nil { return true }
+				if (source != "" && v.Event.Raw.Source != source) || (typ != "" && v.Event.Raw.EventType != typ) { return true }
+				if !ndjson && n > 0 { _, _ = w.WriteString(",") }
+				_ = enc.Encode(v)
+				n++
+				if n%256 == 0 { _ = w.Flush() }
+				return n < limit
+			})
+			if err != nil && ndjson { _ = enc.Encode(map[string]string{"error": err.Error()}) }
+			if n >= limit { break }
+		}
+		if !ndjson { _, _ = w.WriteString("]") }
+	})
+}
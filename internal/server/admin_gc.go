@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"webhook-engine/internal/fastpath"
+)
+
+type gcView struct {
+	Shard int `json:"shard"`
+	fastpath.GCStatus
+}
+
+// GET /admin/gc?route=R[&shard=N]
+func (a *App) gcList(ctx *fasthttp.RequestCtx) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	out := make([]gcView, 0, len(shards))
+	for _, i := range shards {
+		out = append(out, gcView{Shard: i, GCStatus: rt.Shards[i].GCStatus()})
+	}
+	writeJSON(ctx, out, 200)
+}
+
+// POST /admin/gc/pause?route=R[&shard=N] and /admin/gc/resume?...
+//
+// Pausing keeps value-log GC off the disks during peak hours; it is not
+// persisted, so a restart resumes GC.
+func (a *App) gcPause(ctx *fasthttp.RequestCtx, paused bool) {
+	rt, shards, err := a.shardArgs(ctx)
+	if err != nil { writeJSON(ctx, map[string]string{"error": err.Error()}, 400); return }
+	for _, i := range shards {
+		rt.Shards[i].PauseGC(paused)
+	}
+	writeJSON(ctx, map[string]any{"paused": paused, "shards": shards}, 200)
+}
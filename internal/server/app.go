@@ -2,18 +2,26 @@ package server
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 	"webhook-engine/internal/zoomapp"
 	"webhook-engine/pkg/fastqueue"
 	"webhook-engine/pkg/metrics"
+	"webhook-engine/pkg/validators"
 )
 
 type App struct {
-	Cfg  RootConfig
-	Log  *logrus.Logger
-	Fast struct{ Rings []*fastqueue.Ring }
+	Cfg    RootConfig
+	Log    *logrus.Logger
+	Routes []*Route
 }
 
 func NewApp(cfg RootConfig, log *logrus.Logger) *App {
@@ -21,54 +29,162 @@ func NewApp(cfg RootConfig, log *logrus.Logger) *App {
 	return &App{Cfg: cfg, Log: log}
 }
 
-func (a *App) AttachFastRings(shards []*struct{ Ring *fastqueue.Ring }) {
-	a.Fast.Rings = make([]*fastqueue.Ring, len(shards))
-	for i, s := range shards {
-		a.Fast.Rings[i] = s.Ring
-	}
-}
-
 // Handler is the regular non-fast handler (minimal in this sample).
 func (a *App) Handler() fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		if bytes.Equal(ctx.Path(), []byte("/health")) {
 			ctx.SetStatusCode(200); ctx.SetBodyString("ok"); return
 		}
+		if bytes.Equal(ctx.Path(), []byte("/ready")) {
+			a.ready(ctx); return
+		}
+		if bytes.HasPrefix(ctx.Path(), adminPrefix) {
+			a.admin(ctx); return
+		}
 		ctx.SetStatusCode(404)
 	}
 }
 
-// FastHandler wraps CRC pre-handler + fast-path for /webhook/zoom.
+// FastHandler wraps CRC pre-handler + fast-path for the configured routes.
 func (a *App) FastHandler(zcfg zoomapp.Config) fasthttp.RequestHandler {
 	base := a.Handler()
 	crc := zoomapp.ZoomPreHandler(a, zcfg)
 	fast := func(ctx *fasthttp.RequestCtx) {
-		// only for zoom path; otherwise fallback
-		if !bytes.Equal(ctx.Path(), []byte("/webhook/zoom")) || !ctx.IsPost() {
+		// only for route paths; otherwise fallback
+		rt := a.route(ctx.Path())
+		if rt == nil || !ctx.IsPost() || bytes.HasPrefix(ctx.Path(), adminPrefix) {
 			base(ctx); return
 		}
-		sig := append([]byte(nil), ctx.Request.Header.Peek("x-zm-signature")...)
-		ts  := append([]byte(nil), ctx.Request.Header.Peek("x-zm-request-timestamp")...)
-		if len(sig)==0 || len(ts)==0 { ctx.SetStatusCode(400); return }
+		if len(rt.Rings)==0 {
+			ctx.SetStatusCode(503); return
+		}
+		hdrs, ok := rt.Verifier.Extract(&ctx.Request.Header)
+		if !ok {
+			metrics.RejectedTotal.WithLabelValues(validators.Reason(validators.ErrMissingHeader)).Inc()
+			ctx.SetStatusCode(400); return
+		}
 		body := append([]byte(nil), ctx.PostBody()...)
+		ev := fastqueue.Event{Body: body, Hdrs: hdrs, Recv: time.Now().UnixNano(), Remote: ctx.RemoteIP().String(),
+			Trace: traceparent(&ctx.Request.Header), Kept: keptHeaders(&ctx.Request.Header, rt.KeepHeaders)}
 
-		if len(a.Fast.Rings)==0 {
-			ctx.SetStatusCode(503); return
+		// sync routes verify inline so senders see bad signatures as 401
+		if rt.Sync {
+			meta, err := rt.Verifier.Verify(hdrs, body)
+			if err != nil {
+				reason := validators.Reason(err)
+				metrics.InvalidTotal.Inc()
+				metrics.RejectedTotal.WithLabelValues(reason).Inc()
+				rt.Shards[fastqueue.ShardFor(body, len(rt.Shards))].Quarantine.Put(ev, reason)
+				writeJSON(ctx, map[string]string{"error": reason}, 401)
+				return
+			}
+			metrics.ValidatedTotal.Inc()
+			ev.Verified, ev.Check, ev.Meta = true, "sync", meta
+		}
+
+		shard := rt.pick(body)
+		if shard < 0 {
+			if f, isF := rt.Verifier.(validators.Forgetter); isF && ev.Verified { f.Forget(hdrs) }
+			ctx.Response.Header.Set("Retry-After", "5")
+			writeJSON(ctx, map[string]string{"error": "no healthy shard"}, 503)
+			return
+		}
+		// after_persist routes bypass the spill log: the sender waits anyway
+		var done chan error
+		if rt.AckTimeout > 0 {
+			done = make(chan error, 1)
+			ev.Done = done
+		}
+		ok, err := rt.Shards[shard].Enqueue(ev, done == nil)
+		if err != nil {
+			if f, isF := rt.Verifier.(validators.Forgetter); isF && ev.Verified { f.Forget(hdrs) }
+			writeJSON(ctx, map[string]string{"error": "wal: " + err.Error()}, 503)
+			return
 		}
-		shard := fastqueue.ShardFor(body, len(a.Fast.Rings))
-		ok := a.Fast.Rings[shard].TryPush(fastqueue.Event{Body: body, Sig: sig, TS: ts})
 		if !ok {
+			// let the sender's retry of this exact request through
+			if f, isF := rt.Verifier.(validators.Forgetter); isF && ev.Verified { f.Forget(hdrs) }
 			metrics.Dropped429.Inc()
 			ctx.Response.Header.Set("Retry-After", "1")
 			ctx.SetStatusCode(429)
 			return
 		}
 		metrics.ReceivedTotal.Inc()
+		if done != nil && !a.awaitPersist(ctx, rt, hdrs, done) { return }
 		ctx.SetStatusCode(202)
 	}
 	return crc.Wrap(fast)
 }
 
+// traceparent returns the request's W3C traceparent, or a new unsampled one
+// when it has none or a malformed one, so every stored event can be traced.
+func traceparent(h *fasthttp.RequestHeader) string {
+	if tp := h.Peek("traceparent"); validTraceparent(tp) { return string(tp) }
+	var id [24]byte
+	binary.BigEndian.PutUint64(id[0:], rand.Uint64())
+	binary.BigEndian.PutUint64(id[8:], rand.Uint64()|1) // trace ID must not be zero
+	binary.BigEndian.PutUint64(id[16:], rand.Uint64()|1)
+	return "00-" + hex.EncodeToString(id[:16]) + "-" + hex.EncodeToString(id[16:]) + "-00"
+}
+
+// validTraceparent checks the version 00 layout: 00-<32 hex>-<16 hex>-<2 hex>.
+func validTraceparent(tp []byte) bool {
+	if len(tp) != 55 || tp[2] != '-' || tp[35] != '-' || tp[52] != '-' || string(tp[:2]) != "00" { return false }
+	for i, c := range tp {
+		if i == 2 || i == 35 || i == 52 { continue }
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') { return false }
+	}
+	return string(tp[3:35]) != strings.Repeat("0", 32) && string(tp[36:52]) != strings.Repeat("0", 16)
+}
+
+func keptHeaders(h *fasthttp.RequestHeader, names []string) map[string]string {
+	if len(names) == 0 { return nil }
+	kept := make(map[string]string, len(names))
+	for _, name := range names {
+		if v := h.Peek(name); len(v) > 0 { kept[name] = string(v) }
+	}
+	return kept
+}
+
+// awaitPersist blocks until the batch holding the request's event is written
+// or rt.AckTimeout passes, and answers the request itself unless the write
+// succeeded. Verification failures from async validation become 401; storage
+// errors and timeouts become 503, with the signature forgotten so the
+// sender's retry is not taken for a replay.
+func (a *App) awaitPersist(ctx *fasthttp.RequestCtx, rt *Route, hdrs [][]byte, done <-chan error) bool {
+	timer := time.NewTimer(rt.AckTimeout); defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+		if err == nil { return true }
+		if reason := validators.Reason(err); reason != "other" {
+			writeJSON(ctx, map[string]string{"error": reason}, 401); return false
+		}
+	case <-timer.C:
+		err = errors.New("persist timeout")
+	}
+	if f, isF := rt.Verifier.(validators.Forgetter); isF { f.Forget(hdrs) }
+	ctx.Response.Header.Set("Retry-After", "1")
+	writeJSON(ctx, map[string]string{"error": err.Error()}, 503)
+	return false
+}
+
+// ready reports 200 while every route has a healthy shard, 503 otherwise.
+// The body lists the healthy state of each shard.
+func (a *App) ready(ctx *fasthttp.RequestCtx) {
+	code := 200
+	out := map[string][]bool{}
+	for _, rt := range a.Routes {
+		up := false
+		for _, s := range rt.Shards {
+			out[rt.Name] = append(out[rt.Name], s.Healthy())
+			up = up || s.Healthy()
+		}
+		if !up { code = 503 }
+	}
+	writeJSON(ctx, out, code)
+}
+
 // JSON helper (not heavily used here)
 func writeJSON(ctx *fasthttp.RequestCtx, v any, code int) {
 	b, _ := json.Marshal(v)
@@ -1,8 +1,19 @@
 package server
 
 import (
+	"fmt"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
 	"gopkg.in/yaml.v3"
+
+	"webhook-engine/internal/delivery"
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/pkg/events"
+	"webhook-engine/pkg/validators"
 )
 
 type TLSCfg struct {
@@ -14,6 +25,8 @@ type ServerCfg struct {
 	BasePath        string `yaml:"base_path"`
 	ReadTimeoutMS   int    `yaml:"read_timeout_ms"`
 	WriteTimeoutMS  int    `yaml:"write_timeout_ms"`
+	// ShutdownTimeoutS bounds the graceful drain on SIGINT/SIGTERM (default 15).
+	ShutdownTimeoutS int   `yaml:"shutdown_timeout_s"`
 	TLS             TLSCfg `yaml:"tls"`
 }
 type LoggingCfg struct {
@@ -25,6 +38,12 @@ type MetricsCfg struct {
 	Enable bool   `yaml:"enable"`
 	Path   string `yaml:"path"`
 }
+type AdminCfg struct {
+	// Token must be sent as "Authorization: Bearer <token>" to every /admin/
+	// endpoint; without one the endpoints are not served. Same reference
+	// syntax as route secrets.
+	Token string `yaml:"token"`
+}
 type TracingCfg struct {
 	SampleRatio  float64 `yaml:"sample_ratio"`
 	OTLPEndpoint string  `yaml:"otlp_endpoint"`
@@ -34,12 +53,164 @@ type ValidatorsCfg struct {
 		Secret string `yaml:"secret"`
 	} `yaml:"zoom"`
 }
+// SecretCfg is one entry of a route's secret list. Secret is "env:NAME",
+// "file:/path" or a literal; the bounds are RFC 3339 timestamps.
+type SecretCfg struct {
+	ID        string `yaml:"id"`
+	Secret    string `yaml:"secret"`
+	NotBefore string `yaml:"not_before"`
+	NotAfter  string `yaml:"not_after"`
+}
+
+// RouteCfg maps a path prefix (relative to server.base_path) to a provider
+// and its own shard set. Secret is shorthand for a single-entry Secrets list.
+type RouteCfg struct {
+	Name     string      `yaml:"name"`
+	Path     string      `yaml:"path"`
+	Provider string      `yaml:"provider"`
+	Secret   string      `yaml:"secret"`
+	Secrets  []SecretCfg `yaml:"secrets"`
+	Shards   int         `yaml:"shards"`
+	Dir      string      `yaml:"dir"`
+
+	// Validation is "async" (verify after 202, the default) or "sync"
+	// (verify in the handler and answer 401 on failure).
+	Validation string `yaml:"validation"`
+
+	// Ack is "received" (202 once the event is queued, the default) or
+	// "after_persist" (202 once the batch holding it is synced to disk).
+	// AckTimeoutMS bounds the wait; past it the sender gets 503 (default 5000).
+	Ack          string `yaml:"ack"`
+	AckTimeoutMS int    `yaml:"ack_timeout_ms"`
+
+	// replay protection; zero picks the provider default. The cache holds
+	// replay_expected_rps × 2 × clock_skew_s signatures unless sized directly.
+	ClockSkewS        int `yaml:"clock_skew_s"`
+	ReplayCacheSize   int `yaml:"replay_cache_size"`
+	ReplayExpectedRPS int `yaml:"replay_expected_rps"`
+
+	Quarantine QuarantineCfg `yaml:"quarantine"`
+
+	// Spill buffers overflow on disk when a shard's ring is full instead of
+	// answering 429 right away.
+	Spill SpillCfg `yaml:"spill"`
+
+	// WAL logs every accepted event before it is acknowledged and replays
+	// the unpersisted ones after a crash.
+	WAL WALCfg `yaml:"wal"`
+
+	// Dedupe drops events whose idempotency key (provider event ID or body
+	// hash) was stored within the last window_s seconds (default 86400).
+	Dedupe DedupeCfg `yaml:"dedupe"`
+
+	Store       StoreCfg       `yaml:"store"`
+	Compression CompressionCfg `yaml:"compression"`
+	Retention RetentionCfg `yaml:"retention"`
+	GC        GCCfg        `yaml:"gc"`
+
+	// seconds a fetched event may stay unacknowledged before its consumer
+	// group gets it again (default 30)
+	ConsumerVisibilityS int `yaml:"consumer_visibility_s"`
+
+	Forward []ForwardCfg `yaml:"forward"`
+
+	// Encoding is the stored form of events: envelope (default) or
+	// cloudevents (structured JSON).
+	Encoding string `yaml:"encoding"`
+
+	// KeepHeaders names request headers stored in each event's envelope.
+	KeepHeaders []string `yaml:"keep_headers"`
+}
+// ForwardCfg is one downstream destination of a route; zero values pick the
+// delivery package defaults.
+type ForwardCfg struct {
+	Name         string `yaml:"name"`
+	URL          string `yaml:"url"`
+	Concurrency  int    `yaml:"concurrency"`
+	MaxAttempts  int    `yaml:"max_attempts"`
+	BackoffMS    int    `yaml:"backoff_ms"`
+	MaxBackoffMS int    `yaml:"max_backoff_ms"`
+	TimeoutMS    int    `yaml:"timeout_ms"`
+	// Format is raw (the original body, default), cloudevents (structured)
+	// or cloudevents-binary.
+	Format string `yaml:"format"`
+}
+// QuarantineCfg keeps events that fail verification; zero caps mean no limit.
+type QuarantineCfg struct {
+	Enabled  bool  `yaml:"enabled"`
+	TTLS     int   `yaml:"ttl_s"`
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+// SpillCfg caps a shard's overflow log; max_bytes defaults to 256 MiB.
+type SpillCfg struct {
+	Enabled  bool  `yaml:"enabled"`
+	MaxBytes int64 `yaml:"max_bytes"`
+}
+// WALCfg: segment_bytes defaults to 64 MiB; fsync syncs every append, which
+// also covers power loss at the cost of a disk flush per request.
+type WALCfg struct {
+	Enabled      bool  `yaml:"enabled"`
+	SegmentBytes int64 `yaml:"segment_bytes"`
+	Fsync        bool  `yaml:"fsync"`
+}
+type DedupeCfg struct {
+	Enabled bool `yaml:"enabled"`
+	WindowS int  `yaml:"window_s"`
+}
+// StoreCfg picks where events live: "badger" (default) or "segments", an
+// append-only log whose segments rotate at segment_bytes (default 64 MiB).
+// no_sync skips the fsync after each batch.
+type StoreCfg struct {
+	Kind         string `yaml:"kind"`
+	SegmentBytes int64  `yaml:"segment_bytes"`
+	NoSync       bool   `yaml:"no_sync"`
+}
+// CompressionCfg: block (none, snappy, zstd) compresses Badger's tables,
+// value (none, zstd) every stored event. level is the zstd level (default
+// 1). dictionary trains a shared zstd dictionary on dict_samples stored
+// events (default 1000) and again every retrain_s (default 86400).
+type CompressionCfg struct {
+	Block       string `yaml:"block"`
+	Value       string `yaml:"value"`
+	Level       int    `yaml:"level"`
+	Dictionary  bool   `yaml:"dictionary"`
+	DictSamples int    `yaml:"dict_samples"`
+	RetrainS    int    `yaml:"retrain_s"`
+}
+// RetentionCfg limits stored events per shard by age and estimated size;
+// zero keeps everything. interval_s paces the sweep and value-log GC
+// (default 60).
+type RetentionCfg struct {
+	MaxAgeS   int   `yaml:"max_age_s"`
+	MaxBytes  int64 `yaml:"max_bytes"`
+	IntervalS int   `yaml:"interval_s"`
+}
+// GCCfg tunes value-log GC: discard_ratio defaults to 0.5, interval_s to
+// 300; window ("HH:MM-HH:MM", local time) restricts when it runs.
+type GCCfg struct {
+	DiscardRatio      float64 `yaml:"discard_ratio"`
+	IntervalS         int     `yaml:"interval_s"`
+	Window            string  `yaml:"window"`
+	FlattenOnShutdown bool    `yaml:"flatten_on_shutdown"`
+}
+// EncryptionCfg encrypts every shard DB at rest. key is a hex or base64 AES
+// key (16, 24 or 32 bytes), usually an "env:" or "file:" reference; Badger
+// rotates the data keys under it every data_key_rotation_s (default ten
+// days). Changing key needs the rekey command. Routes using wal, spill or
+// the segments store are rejected with a key set.
+type EncryptionCfg struct {
+	Key              string `yaml:"key"`
+	DataKeyRotationS int    `yaml:"data_key_rotation_s"`
+}
 type RootConfig struct {
 	Server     ServerCfg     `yaml:"server"`
 	Logging    LoggingCfg    `yaml:"logging"`
 	Metrics    MetricsCfg    `yaml:"metrics"`
 	Tracing    TracingCfg    `yaml:"tracing"`
 	Validators ValidatorsCfg `yaml:"validators"`
+	Admin      AdminCfg      `yaml:"admin"`
+	Encryption EncryptionCfg `yaml:"encryption"`
+	Routes     []RouteCfg    `yaml:"routes"`
 }
 
 func LoadRootConfig(path string) (RootConfig, error) {
@@ -47,5 +218,119 @@ func LoadRootConfig(path string) (RootConfig, error) {
 	b, err := os.ReadFile(path)
 	if err != nil { return root, err }
 	if err := yaml.Unmarshal(b, &root); err != nil { return root, err }
+	if root.Server.ShutdownTimeoutS <= 0 { root.Server.ShutdownTimeoutS = 15 }
+	if root.Admin.Token, err = ResolveSecret(root.Admin.Token); err != nil { return root, fmt.Errorf("admin.token: %w", err) }
+	if root.Encryption.Key, err = ResolveSecret(root.Encryption.Key); err != nil { return root, fmt.Errorf("encryption.key: %w", err) }
+	if _, err := fastpath.ParseEncryptionKey(root.Encryption.Key); err != nil { return root, fmt.Errorf("encryption.key: %w", err) }
+	// without a routes section keep serving the single Zoom endpoint
+	if len(root.Routes) == 0 {
+		root.Routes = []RouteCfg{{Name: "zoom", Path: "/webhook/zoom", Provider: "zoom"}}
+	}
+	seen := map[string]bool{}
+	for i := range root.Routes {
+		rc := &root.Routes[i]
+		if rc.Name == "" || rc.Path == "" { return root, fmt.Errorf("route %d: name and path are required", i) }
+		if seen[rc.Name] { return root, fmt.Errorf("route %q: duplicate name", rc.Name) }
+		seen[rc.Name] = true
+		if rc.Provider == "" { rc.Provider = "zoom" }
+		for j, fc := range rc.Forward {
+			if fc.Name == "" || fc.URL == "" { return root, fmt.Errorf("route %q: forward %d: name and url are required", rc.Name, j) }
+		}
+		switch rc.Validation {
+		case "":
+			rc.Validation = "async"
+		case "async", "sync":
+		default:
+			return root, fmt.Errorf("route %q: validation must be sync or async", rc.Name)
+		}
+		switch rc.Ack {
+		case "":
+			rc.Ack = "received"
+		case "received", "after_persist":
+		default:
+			return root, fmt.Errorf("route %q: ack must be received or after_persist", rc.Name)
+		}
+		if rc.AckTimeoutMS <= 0 { rc.AckTimeoutMS = 5000 }
+		switch rc.Encoding {
+		case "":
+			rc.Encoding = events.EncodingEnvelope
+		case events.EncodingEnvelope, events.EncodingCloudEvents:
+		default:
+			return root, fmt.Errorf("route %q: encoding must be envelope or cloudevents", rc.Name)
+		}
+		for _, fc := range rc.Forward {
+			switch fc.Format {
+			case "", delivery.FormatRaw, delivery.FormatCloudEvents, delivery.FormatCloudEventsBinary:
+			default:
+				return root, fmt.Errorf("route %q: forward %q: format must be raw, cloudevents or cloudevents-binary", rc.Name, fc.Name)
+			}
+		}
+		for j, h := range rc.KeepHeaders {
+			rc.KeepHeaders[j] = strings.ToLower(h)
+		}
+		if rc.Dedupe.WindowS <= 0 { rc.Dedupe.WindowS = 86400 }
+		switch rc.Store.Kind {
+		case "":
+			rc.Store.Kind = fastpath.StoreBadger
+		case fastpath.StoreBadger, fastpath.StoreSegments:
+		default:
+			return root, fmt.Errorf("route %q: store kind must be badger or segments", rc.Name)
+		}
+		switch rc.Compression.Block {
+		case "", fastpath.CompressNone, fastpath.CompressSnappy, fastpath.CompressZstd:
+		default:
+			return root, fmt.Errorf("route %q: compression block must be none, snappy or zstd", rc.Name)
+		}
+		switch rc.Compression.Value {
+		case "", fastpath.CompressNone, fastpath.CompressZstd:
+		default:
+			return root, fmt.Errorf("route %q: compression value must be none or zstd", rc.Name)
+		}
+		if _, err := fastpath.ParseClockWindow(rc.GC.Window); err != nil { return root, fmt.Errorf("route %q: gc: %w", rc.Name, err) }
+		// those files would hold events in plaintext next to encrypted DBs
+		if root.Encryption.Key != "" && (rc.WAL.Enabled || rc.Spill.Enabled || rc.Store.Kind == fastpath.StoreSegments) {
+			return root, fmt.Errorf("route %q: encryption does not cover wal, spill or the segments store", rc.Name)
+		}
+	}
 	return root, nil
 }
+
+// RoutePath joins the route prefix onto server.base_path.
+func (c RootConfig) RoutePath(rc RouteCfg) string {
+	return path.Join("/", c.Server.BasePath, rc.Path)
+}
+
+// ResolveSecrets dereferences every entry of secrets, in order.
+func ResolveSecrets(secrets []SecretCfg) ([]validators.Secret, error) {
+	out := make([]validators.Secret, 0, len(secrets))
+	for i, sc := range secrets {
+		v, err := ResolveSecret(sc.Secret)
+		if err != nil { return nil, err }
+		s := validators.Secret{ID: sc.ID, Value: v}
+		if s.ID == "" { s.ID = strconv.Itoa(i) }
+		if sc.NotBefore != "" {
+			if s.NotBefore, err = time.Parse(time.RFC3339, sc.NotBefore); err != nil { return nil, fmt.Errorf("secret %q: not_before: %w", s.ID, err) }
+		}
+		if sc.NotAfter != "" {
+			if s.NotAfter, err = time.Parse(time.RFC3339, sc.NotAfter); err != nil { return nil, fmt.Errorf("secret %q: not_after: %w", s.ID, err) }
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ResolveSecret dereferences an "env:" or "file:" secret reference.
+func ResolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		v := os.Getenv(name)
+		if v == "" { return "", fmt.Errorf("%s not set", name) }
+		return v, nil
+	case strings.HasPrefix(ref, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil { return "", err }
+		return strings.TrimSpace(string(b)), nil
+	}
+	return ref, nil
+}
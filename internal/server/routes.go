@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"webhook-engine/internal/delivery"
+	"webhook-engine/internal/fastpath"
+	"webhook-engine/internal/zoomapp"
+	"webhook-engine/pkg/fastqueue"
+	"webhook-engine/pkg/validators"
+	"webhook-engine/pkg/validators/zoom"
+)
+
+// Route is one configured ingestion endpoint and the shard set behind it.
+type Route struct {
+	Name     string
+	Prefix   []byte
+	Provider string
+	Sync     bool
+	// AckTimeout is non-zero on after_persist routes: the handler answers
+	// once the event is on disk, waiting at most this long.
+	AckTimeout time.Duration
+	// KeepHeaders are stored with every event, lower-cased.
+	KeepHeaders []string
+	Secrets    []validators.Secret
+	Verifier   validators.Verifier
+	Shards     []*fastpath.Shard
+	Rings      []*fastqueue.Ring
+	Forwards   []*delivery.Forwarder
+}
+
+func newVerifier(rc RouteCfg, secrets []validators.Secret) (validators.Verifier, error) {
+	switch rc.Provider {
+	case "zoom":
+		return zoom.NewVerifier(zoom.Config{
+			Route:              rc.Name,
+			Secrets:            secrets,
+			TolerateClockSkewS: rc.ClockSkewS,
+			ReplayCacheSize:    rc.ReplayCacheSize,
+			ExpectedRPS:        rc.ReplayExpectedRPS,
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", rc.Provider)
+}
+
+func (a *App) routeSecrets(rc RouteCfg) ([]validators.Secret, error) {
+	if len(rc.Secrets) > 0 { return ResolveSecrets(rc.Secrets) }
+	if rc.Secret == "" && rc.Provider == "zoom" {
+		// zoom routes without a reference keep the env/validators.zoom lookup
+		v, err := zoom.LoadSecretForCRC(a.Cfg.Validators.Zoom.Secret)
+		if err != nil { return nil, err }
+		return []validators.Secret{{ID: "default", Value: v}}, nil
+	}
+	return ResolveSecrets([]SecretCfg{{ID: "default", Secret: rc.Secret}})
+}
+
+// RouteDir is where rc keeps its shards: its own dir, else baseDir, or
+// baseDir/<name> when more than one route is configured.
+func RouteDir(rc RouteCfg, baseDir string, routes int) string {
+	if rc.Dir != "" { return rc.Dir }
+	if routes > 1 { return filepath.Join(baseDir, rc.Name) }
+	return baseDir
+}
+
+// BuildRoutes opens a shard set for every configured route (see RouteDir)
+// and attaches it.
+//
+// The returned stop func should run once the server stopped handling
+// requests; requests still in flight get 429 or 503. It stops the forwarders,
+// then drains every route's shards within ctx and logs how many queued events
+// were persisted and how many were lost.
+func (a *App) BuildRoutes(zcfg zoomapp.Config) (func(context.Context) error, error) {
+	fp := zcfg.Fastpath
+	var stops []func(context.Context) error
+	stopAll := func(ctx context.Context) error {
+		var first error
+		for _, stop := range stops {
+			if err := stop(ctx); err != nil && first == nil { first = err }
+		}
+		return first
+	}
+	encKey, _ := fastpath.ParseEncryptionKey(a.Cfg.Encryption.Key) // checked by LoadRootConfig
+	enc := fastpath.EncryptionOptions{Key: encKey, DataKeyRotation: time.Duration(a.Cfg.Encryption.DataKeyRotationS)*time.Second}
+	for _, rc := range a.Cfg.Routes {
+		secrets, err := a.routeSecrets(rc)
+		if err != nil { _ = stopAll(context.Background()); return nil, fmt.Errorf("route %q: %w", rc.Name, err) }
+		ver, err := newVerifier(rc, secrets)
+		if err != nil { _ = stopAll(context.Background()); return nil, fmt.Errorf("route %q: %w", rc.Name, err) }
+		n := rc.Shards
+		if n == 0 { n = fp.Shards }
+		dir := RouteDir(rc, fp.BaseDir, len(a.Cfg.Routes))
+		window, _ := fastpath.ParseClockWindow(rc.GC.Window) // checked by LoadRootConfig
+		shards, stop, err := fastpath.BuildShards(fastpath.Options{
+			Route:         rc.Name,
+			Shards:        n,
+			BaseDir:       dir,
+			RingSize:      fp.RingSize,
+			ValidatorsPer: fp.ValidatorsPer,
+			BatchSize:     fp.BatchSize,
+			Linger:        time.Duration(fp.BatchLingerMS)*time.Millisecond,
+			Verifier:      ver,
+			Quarantine: fastpath.QuarantineOptions{
+				Enabled:  rc.Quarantine.Enabled,
+				TTL:      time.Duration(rc.Quarantine.TTLS)*time.Second,
+				MaxBytes: rc.Quarantine.MaxBytes,
+			},
+			Spill:      fastpath.SpillOptions{Enabled: rc.Spill.Enabled, MaxBytes: rc.Spill.MaxBytes},
+			Store:      fastpath.StoreOptions{Kind: rc.Store.Kind, SegmentBytes: rc.Store.SegmentBytes, NoSync: rc.Store.NoSync},
+			Encryption: enc,
+			Encoding:   rc.Encoding,
+			Compression: fastpath.CompressionOptions{
+				Block:       rc.Compression.Block,
+				Value:       rc.Compression.Value,
+				Level:       rc.Compression.Level,
+				Dictionary:  rc.Compression.Dictionary,
+				DictSamples: rc.Compression.DictSamples,
+				Retrain:     time.Duration(rc.Compression.RetrainS)*time.Second,
+			},
+			Dedupe:     dedupeWindow(rc),
+			GC: fastpath.GCOptions{
+				DiscardRatio: rc.GC.DiscardRatio,
+				Interval:     time.Duration(rc.GC.IntervalS)*time.Second,
+				Window:       window,
+				Flatten:      rc.GC.FlattenOnShutdown,
+			},
+			Retention: fastpath.RetentionOptions{
+				MaxAge:   time.Duration(rc.Retention.MaxAgeS)*time.Second,
+				MaxBytes: rc.Retention.MaxBytes,
+				Interval: time.Duration(rc.Retention.IntervalS)*time.Second,
+			},
+			WAL:        fastpath.WALOptions{Enabled: rc.WAL.Enabled, SegmentBytes: rc.WAL.SegmentBytes, Fsync: rc.WAL.Fsync},
+			Visibility: time.Duration(rc.ConsumerVisibilityS)*time.Second,
+		})
+		if err != nil { _ = stopAll(context.Background()); return nil, fmt.Errorf("route %q: %w", rc.Name, err) }
+		var fwds []*delivery.Forwarder
+		for _, fc := range rc.Forward {
+			fwds = append(fwds, delivery.Start(rc.Name, delivery.Destination{
+				Name:        fc.Name,
+				URL:         fc.URL,
+				Concurrency: fc.Concurrency,
+				MaxAttempts: fc.MaxAttempts,
+				Backoff:     time.Duration(fc.BackoffMS)*time.Millisecond,
+				MaxBackoff:  time.Duration(fc.MaxBackoffMS)*time.Millisecond,
+				Timeout:     time.Duration(fc.TimeoutMS)*time.Millisecond,
+				Format:      fc.Format,
+			}, shards, a.Log))
+		}
+		// forwarders read the shard DBs, so they stop first
+		name := rc.Name
+		stops = append(stops, func(ctx context.Context) error {
+			for _, f := range fwds {
+				f.Stop()
+			}
+			st, err := stop(ctx)
+			log := a.Log.WithFields(logrus.Fields{"route": name, "drained": st.Drained, "lost": st.Lost})
+			if st.Lost > 0 {
+				log.Error("shutdown deadline passed with events still queued")
+			} else {
+				log.Warn("route drained")
+			}
+			return err
+		})
+		a.AttachRoute(&Route{
+			Name:       rc.Name,
+			Prefix:     []byte(a.Cfg.RoutePath(rc)),
+			Provider:   rc.Provider,
+			Sync:       rc.Validation == "sync",
+			AckTimeout: ackTimeout(rc),
+			KeepHeaders: rc.KeepHeaders,
+			Secrets:    secrets,
+			Verifier:   ver,
+			Shards:     shards,
+			Forwards:   fwds,
+		})
+	}
+	return stopAll, nil
+}
+
+// AttachRoute registers rt, keeping routes ordered longest prefix first.
+func (a *App) AttachRoute(rt *Route) {
+	rt.Rings = make([]*fastqueue.Ring, len(rt.Shards))
+	for i, s := range rt.Shards {
+		rt.Rings[i] = s.Ring
+	}
+	a.Routes = append(a.Routes, rt)
+	sort.SliceStable(a.Routes, func(i, j int) bool { return len(a.Routes[i].Prefix) > len(a.Routes[j].Prefix) })
+}
+
+// route returns the route whose prefix is the longest match for path.
+func (a *App) route(path []byte) *Route {
+	for _, rt := range a.Routes {
+		if !bytes.HasPrefix(path, rt.Prefix) { continue }
+		if len(path) == len(rt.Prefix) || path[len(rt.Prefix)] == '/' || rt.Prefix[len(rt.Prefix)-1] == '/' { return rt }
+	}
+	return nil
+}
+
+func dedupeWindow(rc RouteCfg) time.Duration {
+	if !rc.Dedupe.Enabled { return 0 }
+	return time.Duration(rc.Dedupe.WindowS)*time.Second
+}
+
+func ackTimeout(rc RouteCfg) time.Duration {
+	if rc.Ack != "after_persist" { return 0 }
+	return time.Duration(rc.AckTimeoutMS)*time.Millisecond
+}
+
+// pick returns the shard body hashes to, or the next healthy one when that
+// shard has been marked unhealthy; -1 when none is left.
+func (rt *Route) pick(body []byte) int {
+	n := len(rt.Rings)
+	first := fastqueue.ShardFor(body, n)
+	for i := 0; i < n; i++ {
+		if s := (first + i) % n; rt.Shards[s].Healthy() { return s }
+	}
+	return -1
+}
+
+// ZoomSecret implements zoomapp.SecretLookup. CRC responses use the first
+// secret in the route's list that is currently active.
+func (a *App) ZoomSecret(path []byte) (string, bool) {
+	rt := a.route(path)
+	if rt == nil || rt.Provider != "zoom" { return "", false }
+	s, _ := validators.FirstActive(rt.Secrets, time.Now())
+	return s.Value, true
+}
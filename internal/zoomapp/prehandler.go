@@ -11,19 +11,25 @@ type Middleware func(fasthttp.RequestHandler) fasthttp.RequestHandler
 type wrapper struct{ mw Middleware }
 func (w wrapper) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler { return w.mw(next) }
 
+// SecretLookup resolves the Zoom secret of the route serving path; ok is
+// false for paths that are not Zoom routes.
+type SecretLookup interface {
+	ZoomSecret(path []byte) (secret string, ok bool)
+}
+
 // ZoomPreHandler intercepts Zoom CRC validation requests and responds immediately.
-func ZoomPreHandler(_ any, _ Config) wrapper {
+func ZoomPreHandler(routes SecretLookup, _ Config) wrapper {
 	return wrapper{mw: func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return func(ctx *fasthttp.RequestCtx) {
-			if string(ctx.Method()) == fasthttp.MethodPost && string(ctx.Path()) == "/webhook/zoom" {
+			secret, ok := routes.ZoomSecret(ctx.Path())
+			if ok && string(ctx.Method()) == fasthttp.MethodPost {
 				var req struct {
 					Event   string `json:"event"`
 					Payload struct{ PlainToken string `json:"plainToken"` } `json:"payload"`
 				}
 				body := ctx.PostBody()
 				if json.Unmarshal(body, &req) == nil && req.Event == "endpoint.url_validation" && req.Payload.PlainToken != "" {
-					secret, err := zoom.LoadSecretForCRC("")
-					if err != nil { ctx.SetStatusCode(500); return }
+					if secret == "" { ctx.SetStatusCode(500); return }
 					enc := zoom.EncryptPlainToken(secret, req.Payload.PlainToken)
 					resp := struct {
 						PlainToken     string `json:"plainToken"`
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage encodings of a route's events.
+const (
+	EncodingEnvelope    = "envelope"    // FormatV1, see AppendValid
+	EncodingCloudEvents = "cloudevents" // structured-mode CloudEvents JSON
+)
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON form. Besides the
+// core attributes it carries what the envelope knows as extensions, so a
+// stored CloudEvent decodes back into a Valid; only kept headers are lost,
+// as extension values cannot be maps.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+
+	Provider     string `json:"provider,omitempty"`
+	ReceivedAt   string `json:"receivedat,omitempty"`
+	RemoteIP     string `json:"remoteip,omitempty"`
+	Verification string `json:"verification,omitempty"`
+	Shard        int    `json:"shard"`
+	Traceparent  string `json:"traceparent,omitempty"` // distributed tracing extension
+}
+
+// ToCloudEvent maps v: type is the provider event name, source the route,
+// id the idempotency key (or a hash of the body when the provider has none)
+// and time the provider's event time, else the receive time.
+func ToCloudEvent(v Valid) CloudEvent {
+	r := v.Raw
+	ce := CloudEvent{SpecVersion: "1.0", ID: r.ID, Source: r.Route, Type: r.EventType, Provider: r.Source,
+		RemoteIP: r.RemoteIP, Verification: r.Verification, Shard: r.Shard, Traceparent: r.Traceparent}
+	if ce.ID == "" {
+		sum := sha256.Sum256(r.Body)
+		ce.ID = "sha256:" + hex.EncodeToString(sum[:16])
+	}
+	if ce.Source == "" { ce.Source = r.Source }
+	if ce.Type == "" { ce.Type = r.Source }
+	switch {
+	case r.EventTS > 0:
+		ce.Time = time.UnixMilli(r.EventTS).UTC().Format(time.RFC3339Nano)
+	case r.ReceivedAt > 0:
+		ce.Time = time.Unix(0, r.ReceivedAt).UTC().Format(time.RFC3339Nano)
+	}
+	if r.ReceivedAt > 0 { ce.ReceivedAt = time.Unix(0, r.ReceivedAt).UTC().Format(time.RFC3339Nano) }
+	if r.Format == "json" && verbatimJSON(r.Body) {
+		ce.DataContentType, ce.Data = "application/json", r.Body
+	} else {
+		ce.DataContentType, ce.DataBase64 = "application/octet-stream", r.Body
+	}
+	return ce
+}
+
+// verbatimJSON reports whether b survives as data unchanged. encoding/json
+// compacts and HTML-escapes embedded JSON, and the stored body must stay
+// byte-exact for signature checks and dedupe, so anything else goes to
+// data_base64.
+func verbatimJSON(b []byte) bool {
+	out, err := json.Marshal(json.RawMessage(b))
+	return err == nil && bytes.Equal(out, b)
+}
+
+// Valid maps ce back; see ToCloudEvent.
+func (ce CloudEvent) Valid() Valid {
+	r := Raw{Source: ce.Provider, EventType: ce.Type, Route: ce.Source, ID: ce.ID, RemoteIP: ce.RemoteIP,
+		Verification: ce.Verification, Shard: ce.Shard, Traceparent: ce.Traceparent, Format: "raw", Body: ce.Body()}
+	if ce.DataContentType == "application/json" { r.Format = "json" }
+	if t, err := time.Parse(time.RFC3339Nano, ce.ReceivedAt); err == nil { r.ReceivedAt = t.UnixNano() }
+	// a time equal to receivedat is the fallback, not a provider event time
+	if t, err := time.Parse(time.RFC3339Nano, ce.Time); err == nil && ce.Time != ce.ReceivedAt { r.EventTS = t.UnixMilli() }
+	return Valid{Raw: r}
+}
+
+// Body is the event data, the HTTP body in binary mode.
+func (ce CloudEvent) Body() []byte {
+	if ce.Data != nil { return ce.Data }
+	return ce.DataBase64
+}
+
+// MarshalCloudEvent encodes v as a structured-mode CloudEvent.
+func MarshalCloudEvent(v Valid) ([]byte, error) { return json.Marshal(ToCloudEvent(v)) }
+
+// HTTPHeaders returns ce's binary-mode HTTP headers: ce-* for every
+// attribute, Content-Type for datacontenttype and the W3C traceparent.
+func (ce CloudEvent) HTTPHeaders() map[string]string {
+	h := map[string]string{"ce-specversion": ce.SpecVersion, "ce-id": ceHeader(ce.ID), "ce-source": ceHeader(ce.Source), "ce-type": ceHeader(ce.Type),
+		"ce-shard": strconv.Itoa(ce.Shard)}
+	for name, v := range map[string]string{"ce-time": ce.Time, "ce-provider": ce.Provider, "ce-receivedat": ce.ReceivedAt,
+		"ce-remoteip": ce.RemoteIP, "ce-verification": ce.Verification, "traceparent": ce.Traceparent, "Content-Type": ce.DataContentType} {
+		if v != "" { h[name] = ceHeader(v) }
+	}
+	return h
+}
+
+// ceHeader percent-encodes what the HTTP binding does not allow verbatim.
+func ceHeader(s string) string {
+	if strings.IndexFunc(s, ceEscaped) < 0 { return s }
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; ceEscaped(rune(c)) { fmt.Fprintf(&b, "%%%02X", c) } else { b.WriteByte(c) }
+	}
+	return b.String()
+}
+
+func ceEscaped(c rune) bool { return c <= ' ' || c >= 0x7f || c == '"' || c == '%' }
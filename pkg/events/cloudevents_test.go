@@ -0,0 +1,32 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCloudEventRoundTrip(t *testing.T) {
+	for _, body := range []string{`{"event":"meeting.started","event_ts":1}`, `{"a": "<b>"}`, "not json"} {
+		v := sample()
+		v.Raw.Headers = nil // not kept in CloudEvents
+		v.Raw.Body = []byte(body)
+		b, err := MarshalCloudEvent(v)
+		if err != nil { t.Fatal(err) }
+		got, err := UnmarshalValid(b)
+		if err != nil { t.Fatal(err) }
+		if string(got.Raw.Body) != body { t.Fatalf("body %q, want %q", got.Raw.Body, body) }
+		got.Raw.Format, v.Raw.Format = "", "" // json only when stored as data
+		if !reflect.DeepEqual(got, v) { t.Fatalf("got %+v\nwant %+v", got, v) }
+	}
+}
+
+func TestCloudEventHeaders(t *testing.T) {
+	v := sample()
+	v.Raw.ID = "id with spaces/%"
+	h := ToCloudEvent(v).HTTPHeaders()
+	want := map[string]string{"ce-specversion": "1.0", "ce-id": "id%20with%20spaces/%25", "ce-source": "zoom",
+		"ce-type": "meeting.participant_joined", "traceparent": v.Raw.Traceparent, "Content-Type": "application/octet-stream"}
+	for k, w := range want {
+		if h[k] != w { t.Errorf("%s = %q, want %q", k, h[k], w) }
+	}
+}
@@ -1,9 +1,153 @@
 package events
 
-import "encoding/json"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Stored envelopes start with a format byte. The first format was plain
+// JSON, whose first byte is always '{'; it is still read but no longer
+// written.
+//
+// FormatV1 is followed by fields, each a tag byte, a uvarint length and the
+// value. Decoders skip tags they do not know, so fields can be added
+// without a new format.
+const FormatV1 byte = 1
+
+const (
+	tagSource byte = iota + 1
+	tagEventType
+	tagFormat
+	tagBody
+	tagReceivedAt
+	tagRemoteIP
+	tagHeader // uvarint name length | name | value, once per header
+	tagEventTS
+	tagVerification
+	tagShard
+	tagTraceparent
+	tagRoute
+	tagID
+)
+
+var errTruncated = errors.New("events: truncated envelope")
+
+func appendField(dst []byte, tag byte, v []byte) []byte {
+	dst = append(dst, tag)
+	dst = binary.AppendUvarint(dst, uint64(len(v)))
+	return append(dst, v...)
+}
+
+func appendString(dst []byte, tag byte, s string) []byte {
+	if s == "" { return dst }
+	dst = append(dst, tag)
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func appendInt(dst []byte, tag byte, n int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return appendField(dst, tag, b[:binary.PutVarint(b[:], n)])
+}
+
+func appendHeader(dst []byte, name, val string) []byte {
+	var b [binary.MaxVarintLen64]byte
+	nl := binary.PutUvarint(b[:], uint64(len(name)))
+	dst = append(dst, tagHeader)
+	dst = binary.AppendUvarint(dst, uint64(nl+len(name)+len(val)))
+	dst = append(dst, b[:nl]...)
+	return append(append(dst, name...), val...)
+}
+
+// AppendValid appends the FormatV1 envelope of v to dst.
+func AppendValid(dst []byte, v Valid) []byte {
+	dst = append(dst, FormatV1)
+	dst = appendString(dst, tagSource, v.Raw.Source)
+	dst = appendString(dst, tagEventType, v.Raw.EventType)
+	dst = appendString(dst, tagFormat, v.Raw.Format)
+	if v.Raw.ReceivedAt != 0 { dst = appendInt(dst, tagReceivedAt, v.Raw.ReceivedAt) }
+	dst = appendString(dst, tagRemoteIP, v.Raw.RemoteIP)
+	for name, val := range v.Raw.Headers {
+		dst = appendHeader(dst, name, val)
+	}
+	if v.Raw.EventTS != 0 { dst = appendInt(dst, tagEventTS, v.Raw.EventTS) }
+	dst = appendString(dst, tagVerification, v.Raw.Verification)
+	dst = appendInt(dst, tagShard, int64(v.Raw.Shard))
+	dst = appendString(dst, tagTraceparent, v.Raw.Traceparent)
+	dst = appendString(dst, tagRoute, v.Raw.Route)
+	dst = appendString(dst, tagID, v.Raw.ID)
+	return appendField(dst, tagBody, v.Raw.Body)
+}
 
 func MarshalValid(v Valid) []byte {
-	b, err := json.Marshal(v)
-	if err != nil { return nil }
-	return b
+	r := v.Raw
+	n := 64 + len(r.Source) + len(r.EventType) + len(r.Format) + len(r.RemoteIP) + len(r.Verification) + len(r.Traceparent) + len(r.Route) + len(r.ID) + len(r.Body)
+	for name, val := range r.Headers {
+		n += 8 + len(name) + len(val)
+	}
+	return AppendValid(make([]byte, 0, n), v)
+}
+
+// UnmarshalValid decodes a FormatV1 envelope, a structured CloudEvent or a
+// legacy JSON envelope. The body is copied out of b.
+func UnmarshalValid(b []byte) (Valid, error) {
+	var v Valid
+	if len(b) == 0 { return v, errTruncated }
+	switch b[0] {
+	case '{':
+		// legacy envelope or a structured CloudEvent (EncodingCloudEvents)
+		var j struct {
+			CloudEvent
+			Raw *Raw `json:"raw"`
+		}
+		if err := json.Unmarshal(b, &j); err != nil { return v, err }
+		if j.SpecVersion != "" { return j.CloudEvent.Valid(), nil }
+		if j.Raw != nil { v.Raw = *j.Raw }
+		return v, nil
+	case FormatV1:
+	default:
+		return v, fmt.Errorf("events: unknown envelope format %#x", b[0])
+	}
+	for b = b[1:]; len(b) > 0; {
+		tag := b[0]
+		n, w := binary.Uvarint(b[1:])
+		if w <= 0 || uint64(len(b)-1-w) < n { return v, errTruncated }
+		f := b[1+w : 1+w+int(n)]
+		b = b[1+w+int(n):]
+		switch tag {
+		case tagSource:
+			v.Raw.Source = string(f)
+		case tagEventType:
+			v.Raw.EventType = string(f)
+		case tagFormat:
+			v.Raw.Format = string(f)
+		case tagBody:
+			v.Raw.Body = append([]byte(nil), f...)
+		case tagReceivedAt:
+			v.Raw.ReceivedAt, _ = binary.Varint(f)
+		case tagRemoteIP:
+			v.Raw.RemoteIP = string(f)
+		case tagHeader:
+			nl, w := binary.Uvarint(f)
+			if w <= 0 || uint64(len(f)-w) < nl { return v, errTruncated }
+			if v.Raw.Headers == nil { v.Raw.Headers = map[string]string{} }
+			v.Raw.Headers[string(f[w:w+int(nl)])] = string(f[w+int(nl):])
+		case tagEventTS:
+			v.Raw.EventTS, _ = binary.Varint(f)
+		case tagVerification:
+			v.Raw.Verification = string(f)
+		case tagShard:
+			n, _ := binary.Varint(f)
+			v.Raw.Shard = int(n)
+		case tagTraceparent:
+			v.Raw.Traceparent = string(f)
+		case tagRoute:
+			v.Raw.Route = string(f)
+		case tagID:
+			v.Raw.ID = string(f)
+		}
+	}
+	return v, nil
 }
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func sample() Valid {
+	body := []byte(`{"event":"meeting.participant_joined","event_ts":1760000000000,"payload":{"account_id":"abc","object":{"uuid":"u1","participant":{"user_name":"Jane","email":"jane@example.com"}}}}`)
+	body = append(body, bytes.Repeat([]byte(" "), 800-len(body))...)
+	return Valid{Raw: Raw{Source: "zoom", EventType: "meeting.participant_joined", Format: "json", Body: body,
+		ReceivedAt: 1760000000123456789, RemoteIP: "203.0.113.7", Headers: map[string]string{"user-agent": "Zoom Marketplace/1.0"},
+		EventTS: 1760000000000, Verification: "async", Shard: 3, Traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Route: "zoom", ID: "zoom:0123456789abcdef"}}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, v := range []Valid{sample(), {Raw: Raw{Source: "zoom", Format: "raw"}}} {
+		b := MarshalValid(v)
+		if b[0] != FormatV1 { t.Fatalf("format byte %#x", b[0]) }
+		got, err := UnmarshalValid(b)
+		if err != nil { t.Fatal(err) }
+		if !reflect.DeepEqual(got, v) { t.Fatalf("got %+v\nwant %+v", got, v) }
+	}
+}
+
+func TestUnmarshalLegacyJSON(t *testing.T) {
+	v := sample()
+	b, err := json.Marshal(v)
+	if err != nil { t.Fatal(err) }
+	got, err := UnmarshalValid(b)
+	if err != nil { t.Fatal(err) }
+	if !reflect.DeepEqual(got, v) { t.Fatalf("got %+v\nwant %+v", got, v) }
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	b := MarshalValid(sample())
+	if _, err := UnmarshalValid(nil); err == nil { t.Fatal("empty input decoded") }
+	// every cut lands inside the trailing body field
+	for _, n := range []int{2, 3, len(b) / 2, len(b) - 1} {
+		if _, err := UnmarshalValid(b[:n]); err != errTruncated { t.Fatalf("cut at %d: err %v", n, err) }
+	}
+	if _, err := UnmarshalValid([]byte{0x7f}); err == nil { t.Fatal("unknown format decoded") }
+}
+
+func TestUnmarshalSkipsUnknownTags(t *testing.T) {
+	v := sample()
+	b := MarshalValid(v)
+	var in []byte
+	in = append(in, b[0])
+	in = appendField(in, 0xee, []byte("from a newer writer"))
+	in = append(in, b[1:]...)
+	got, err := UnmarshalValid(in)
+	if err != nil { t.Fatal(err) }
+	if !reflect.DeepEqual(got, v) { t.Fatalf("got %+v\nwant %+v", got, v) }
+}
+
+func BenchmarkMarshalValid(b *testing.B) {
+	v := sample()
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(v); err != nil { b.Fatal(err) }
+		}
+	})
+	b.Run("v1", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			MarshalValid(v)
+		}
+	})
+}
+
+func BenchmarkUnmarshalValid(b *testing.B) {
+	v := sample()
+	legacy, _ := json.Marshal(v)
+	for _, c := range []struct {
+		name string
+		in   []byte
+	}{{"json", legacy}, {"v1", MarshalValid(v)}} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(c.in)))
+			for i := 0; i < b.N; i++ {
+				if _, err := UnmarshalValid(c.in); err != nil { b.Fatal(err) }
+			}
+		})
+	}
+}
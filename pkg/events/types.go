@@ -1,9 +1,24 @@
 package events
 
+// Raw is a received webhook as stored. EventType is the provider's event
+// name (Zoom: "event"), EventTS its event time (Zoom: "event_ts", unix ms).
+// Verification says how the signature was checked: "sync" in the handler,
+// "async" by a shard validator, or "signature" alone for events replayed
+// after their timestamp went stale.
 type Raw struct {
-	Source string `json:"source"`
-	Format string `json:"format"`
-	Body   []byte `json:"body"`
+	Source       string            `json:"source"`
+	EventType    string            `json:"event_type,omitempty"`
+	Format       string            `json:"format"`
+	Body         []byte            `json:"body"`
+	ReceivedAt   int64             `json:"received_at,omitempty"` // unix ns
+	RemoteIP     string            `json:"remote_ip,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	EventTS      int64             `json:"event_ts,omitempty"`
+	Verification string            `json:"verification,omitempty"`
+	Shard        int               `json:"shard"`
+	Traceparent  string            `json:"traceparent,omitempty"`
+	Route        string            `json:"route,omitempty"`
+	ID           string            `json:"id,omitempty"` // idempotency key, see validators.Meta
 }
 type Valid struct {
 	Raw Raw `json:"raw"`
@@ -1,16 +1,50 @@
 package fastqueue
 
+import (
+	"sync"
+
+	"webhook-engine/pkg/validators"
+)
+
+// Event is a raw delivery plus the provider headers its Verifier extracted.
+// Verified events were already checked by the handler (sync validation) and
+// carry the resulting Meta. When Done is set the pipeline reports the
+// event's fate on it exactly once: nil after a durable write, otherwise the
+// verification or storage error. It must be buffered.
 type Event struct {
-	Body []byte
-	Sig  []byte
-	TS   []byte
+	Body     []byte
+	Hdrs     [][]byte
+	Recv     int64 // receive time, unix ns
+	Verified bool
+	Check    string // how Verified was established: "sync" or "signature"
+	Meta     validators.Meta
+	Done     chan<- error
+	WALSeq   uint64 // write-ahead log sequence; 0 when not logged
+	Remote   string            // client IP
+	Trace    string            // W3C traceparent, the sender's or a fresh one
+	Kept     map[string]string // the route's keep_headers, by lower-case name
 }
 
-type Ring struct{ ch chan Event }
+// Ring is a bounded event queue. Pushes racing Close are refused rather than
+// sent on the closed channel.
+type Ring struct {
+	ch     chan Event
+	mu     sync.RWMutex
+	closed bool
+}
 func NewRing(capacity int) *Ring { return &Ring{ch: make(chan Event, capacity)} }
+// TryPush queues e without blocking. It reports false when the ring is full
+// or closed.
 func (r *Ring) TryPush(e Event) bool {
+	r.mu.RLock(); defer r.mu.RUnlock()
+	if r.closed { return false }
 	select { case r.ch <- e: return true; default: return false }
 }
 func (r *Ring) Pop() Event { return <-r.ch }
 func (r *Ring) Len() int { return len(r.ch) }
 func (r *Ring) C() <-chan Event { return r.ch }
+// Close ends the ring once its events are consumed; later pushes fail.
+func (r *Ring) Close() {
+	r.mu.Lock(); defer r.mu.Unlock()
+	if !r.closed { r.closed = true; close(r.ch) }
+}
@@ -6,10 +6,28 @@ var (
 	ReceivedTotal   = prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_received_total", Help: "events received"})
 	ValidatedTotal  = prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_validated_total", Help: "events validated"})
 	InvalidTotal    = prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_invalid_total", Help: "invalid events"})
+	RejectedTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_rejected_total", Help: "events rejected by verifiers"}, []string{"reason"})
+	SecretMatched   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_secret_matched_total", Help: "signatures verified per secret"}, []string{"route", "secret"})
+	ReplayEvictedLive = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_replay_evicted_live_total", Help: "unexpired signatures evicted from a full replay cache; replays of them go undetected"}, []string{"route"})
+	QuarantinedTotal  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_quarantined_total", Help: "rejected events quarantined"}, []string{"reason"})
+	QuarantineDropped = prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_quarantine_dropped_total", Help: "rejected events not quarantined (writer busy)"})
+	DeliveryTotal     = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_delivery_total", Help: "forwarding attempts by result (ok, retry, dead)"}, []string{"route", "dest", "result"})
+	DLQDepth          = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "webhook_dlq_depth", Help: "dead-letter entries per shard"}, []string{"route", "shard"})
+	LostTotal         = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_lost_total", Help: "events lost because not even the dead-letter write succeeded"}, []string{"stage"})
+	WriteErrors       = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_write_errors_total", Help: "failed Badger writes per shard"}, []string{"route", "shard"})
+	ShardHealthy      = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "webhook_shard_healthy", Help: "1 while a shard accepts writes"}, []string{"route", "shard"})
+	SpillBytes        = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "webhook_spill_bytes", Help: "overflow bytes waiting in a shard's spill log"}, []string{"route", "shard"})
+	DuplicatesTotal   = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_duplicates_total", Help: "events dropped as duplicates of a stored event"}, []string{"route"})
+	RetentionDeleted  = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_retention_deleted_total", Help: "stored events deleted by retention (age, size)"}, []string{"route", "reason"})
+	ReclaimedBytes    = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_reclaimed_bytes_total", Help: "value log bytes freed by GC"}, []string{"route", "shard"})
+	DiskBytes         = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "webhook_disk_bytes", Help: "shard DB size on disk (lsm, vlog)"}, []string{"route", "shard", "kind"})
+	GCRuns            = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "webhook_gc_runs_total", Help: "value-log GC ticks by result (ran, skipped)"}, []string{"route", "shard", "result"})
+	GCDuration        = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "webhook_gc_duration_seconds", Help: "value-log GC run time", Buckets: prometheus.ExponentialBuckets(0.01, 4, 8)}, []string{"route", "shard"})
+	CompressionRatio  = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "webhook_compression_ratio", Help: "uncompressed over stored bytes per shard (block: Badger tables, value: events appended since start)"}, []string{"route", "shard", "level"})
 	Dropped429      = prometheus.NewCounter(prometheus.CounterOpts{Name: "webhook_dropped_429_total", Help: "429 drops"})
-	FastShardQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "fast_shard_queued", Help: "queued per shard"}, []string{"shard"})
+	FastShardQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "fast_shard_queued", Help: "queued per shard"}, []string{"route", "shard"})
 )
 
 func RegisterAll() {
-	prometheus.MustRegister(ReceivedTotal, ValidatedTotal, InvalidTotal, Dropped429, FastShardQueued)
+	prometheus.MustRegister(ReceivedTotal, ValidatedTotal, InvalidTotal, RejectedTotal, SecretMatched, ReplayEvictedLive, QuarantinedTotal, QuarantineDropped, DeliveryTotal, DLQDepth, LostTotal, WriteErrors, ShardHealthy, SpillBytes, DuplicatesTotal, RetentionDeleted, ReclaimedBytes, DiskBytes, GCRuns, GCDuration, CompressionRatio, Dropped429, FastShardQueued)
 }
@@ -0,0 +1,83 @@
+// Package replay remembers recently seen signatures so a captured request
+// cannot be re-submitted while its timestamp is still inside the skew window.
+package replay
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+const shardCount = 16
+
+// Cache is a bounded, sharded set of signature fingerprints with expiry.
+// When a shard is full the oldest fingerprint is evicted first.
+type Cache struct {
+	seed   maphash.Seed
+	ttl    time.Duration
+	shards [shardCount]shard
+
+	// LiveEvicted, if set, is called when a full shard evicts a fingerprint
+	// that has not expired: the cache is too small for the request rate and
+	// that signature can be replayed. It runs under the shard's lock.
+	LiveEvicted func()
+}
+
+type shard struct {
+	mu    sync.Mutex
+	seen  map[uint64]entry
+	order []uint64 // insertion ring used for eviction
+	next  int
+}
+
+// entry is a recorded fingerprint. slot is its position in order: a
+// fingerprint that was forgotten or expired and then recorded again also
+// sits in its old slot, whose eviction must leave the new entry alone.
+type entry struct {
+	exp  int64 // unix ns
+	slot int
+}
+
+// New returns a cache holding at most size fingerprints, each for ttl.
+func New(size int, ttl time.Duration) *Cache {
+	per := size / shardCount
+	if per < 1 { per = 1 }
+	c := &Cache{seed: maphash.MakeSeed(), ttl: ttl}
+	for i := range c.shards {
+		c.shards[i].seen = make(map[uint64]entry, per)
+		c.shards[i].order = make([]uint64, per)
+	}
+	return c
+}
+
+// Seen records sig and reports whether it was already recorded and has not
+// expired yet.
+func (c *Cache) Seen(sig []byte, now time.Time) bool {
+	fp := maphash.Bytes(c.seed, sig)
+	s := &c.shards[fp%shardCount]
+	ns := now.UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.seen[fp]; ok && e.exp > ns { return true }
+	if old := s.order[s.next]; old != 0 {
+		if e, ok := s.seen[old]; ok && e.slot == s.next {
+			delete(s.seen, old)
+			if e.exp > ns && c.LiveEvicted != nil { c.LiveEvicted() }
+		}
+	}
+	s.order[s.next] = fp
+	s.seen[fp] = entry{exp: ns + int64(c.ttl), slot: s.next}
+	s.next = (s.next + 1) % len(s.order)
+	return false
+}
+
+// Forget drops sig, for callers that verified a request but could not
+// accept it and expect the sender to retry with the same signature.
+func (c *Cache) Forget(sig []byte) {
+	fp := maphash.Bytes(c.seed, sig)
+	s := &c.shards[fp%shardCount]
+	s.mu.Lock()
+	delete(s.seen, fp)
+	s.mu.Unlock()
+}
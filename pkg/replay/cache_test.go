@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"fmt"
+	"hash/maphash"
+	"testing"
+	"time"
+)
+
+// sameShard returns n signatures that land in one shard of c.
+func sameShard(c *Cache, n int) [][]byte {
+	var out [][]byte
+	want := -1
+	for i := 0; len(out) < n; i++ {
+		sig := []byte(fmt.Sprintf("v0=%064d", i))
+		sh := int(maphash.Bytes(c.seed, sig) % shardCount)
+		if want < 0 { want = sh }
+		if sh == want { out = append(out, sig) }
+	}
+	return out
+}
+
+func TestSeen(t *testing.T) {
+	c := New(shardCount*4, time.Minute)
+	now := time.Now()
+	sig := []byte("v0=abc")
+	if c.Seen(sig, now) { t.Fatal("first Seen reported a replay") }
+	if !c.Seen(sig, now) { t.Fatal("second Seen missed the replay") }
+	if c.Seen(sig, now.Add(2*time.Minute)) { t.Fatal("expired signature reported as a replay") }
+}
+
+func TestForgetThenEvictOldSlot(t *testing.T) {
+	c := New(shardCount*2, time.Minute) // two slots per shard
+	sigs := sameShard(c, 2)
+	sig, other := sigs[0], sigs[1]
+	now := time.Now()
+
+	c.Seen(sig, now)
+	c.Forget(sig)
+	if c.Seen(sig, now) { t.Fatal("forgotten signature reported as a replay") }
+	// evicts the slot sig had before Forget
+	c.Seen(other, now)
+	if !c.Seen(sig, now) { t.Fatal("eviction of a stale slot dropped the live entry") }
+}
+
+func TestExpiredThenEvictOldSlot(t *testing.T) {
+	c := New(shardCount*2, time.Minute)
+	sigs := sameShard(c, 2)
+	sig, other := sigs[0], sigs[1]
+	now := time.Now()
+
+	c.Seen(sig, now)
+	later := now.Add(2 * time.Minute)
+	if c.Seen(sig, later) { t.Fatal("expired signature reported as a replay") }
+	c.Seen(other, later)
+	if !c.Seen(sig, later) { t.Fatal("eviction of a stale slot dropped the refreshed entry") }
+}
+
+func TestEvictsOldest(t *testing.T) {
+	c := New(shardCount*2, time.Minute)
+	live := 0
+	c.LiveEvicted = func() { live++ }
+	sigs := sameShard(c, 3)
+	now := time.Now()
+	for _, sig := range sigs {
+		c.Seen(sig, now)
+	}
+	if live != 1 { t.Fatalf("LiveEvicted called %d times, want 1", live) }
+	if c.Seen(sigs[0], now) { t.Fatal("oldest signature was not evicted") }
+}
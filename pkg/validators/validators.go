@@ -0,0 +1,87 @@
+// Package validators defines the contract the fast path uses to authenticate
+// webhook deliveries, so each provider plugs in without touching the pipeline.
+package validators
+
+import (
+	"errors"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	ErrMissingHeader = errors.New("missing signature headers")
+	ErrSignature     = errors.New("signature mismatch")
+	ErrTimestamp     = errors.New("timestamp outside tolerated skew")
+	ErrReplay        = errors.New("signature already seen")
+)
+
+// Reason maps a Verify error to the short label used in metrics and responses.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingHeader):
+		return "missing_header"
+	case errors.Is(err, ErrSignature):
+		return "signature"
+	case errors.Is(err, ErrTimestamp):
+		return "timestamp"
+	case errors.Is(err, ErrReplay):
+		return "replay"
+	}
+	return "other"
+}
+
+// Secret is one signing secret. Zero NotBefore/NotAfter leave that side of
+// the validity window open, so old and new secrets can overlap on rotation.
+type Secret struct {
+	ID        string
+	Value     string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func (s Secret) Active(t time.Time) bool {
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) { return false }
+	if !s.NotAfter.IsZero() && !t.Before(s.NotAfter) { return false }
+	return true
+}
+
+// FirstActive returns the first secret in secrets that is active at t.
+func FirstActive(secrets []Secret, t time.Time) (Secret, bool) {
+	for _, s := range secrets {
+		if s.Active(t) { return s, true }
+	}
+	return Secret{}, false
+}
+
+// Meta is what a Verifier learned about an event while verifying it.
+// ID is the event's idempotency key; empty when the provider has none and
+// the body itself has to serve.
+type Meta struct {
+	Source    string
+	EventType string
+	EventTS   int64 // provider's event time; Zoom: event_ts, unix ms
+	ID        string
+}
+
+// Verifier authenticates one provider's webhooks.
+type Verifier interface {
+	// Extract copies the headers Verify needs out of the request. It reports
+	// false when any of them are missing.
+	Extract(h *fasthttp.RequestHeader) ([][]byte, bool)
+	// Verify checks body against the headers returned by Extract.
+	Verify(hdrs [][]byte, body []byte) (Meta, error)
+}
+
+// Forgetter is implemented by verifiers with replay protection. Forget undoes
+// the replay bookkeeping of a verified request that was not accepted.
+type Forgetter interface {
+	Forget(hdrs [][]byte)
+}
+
+// SignatureVerifier is implemented by verifiers that can check a signature
+// alone, without the freshness and replay checks Verify applies. It is used
+// to re-verify quarantined events, whose timestamps are necessarily stale.
+type SignatureVerifier interface {
+	VerifySignature(hdrs [][]byte, body []byte) (Meta, error)
+}
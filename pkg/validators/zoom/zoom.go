@@ -6,14 +6,24 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+
+	"webhook-engine/pkg/validators"
 )
 
 const EnvToken = "ZOOM_WEBHOOK_SECRET_TOKEN"
 
+const (
+	DefaultClockSkewS  = 300
+	DefaultExpectedRPS = 1000
+)
+
 type Config struct {
-	Secret             string
+	Route              string
+	Secrets            []validators.Secret // tried in order
 	TolerateClockSkewS int
 	LegacyV0Fallback   bool
+	ReplayCacheSize    int // default: ExpectedRPS × the 2×skew window
+	ExpectedRPS        int // peak requests per second the replay cache must cover
 }
 
 func LoadSecretForCRC(fallback string) (string, error) {